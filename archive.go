@@ -0,0 +1,65 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// archiveMetadata describes an archived response. It is saved alongside the
+// body of the response.
+type archiveMetadata struct {
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"statusCode"`
+	Time       time.Time `json:"time"`
+}
+
+// archive saves the raw body of the response to a uniquely-named file in
+// ResponseArchiveDir, along with a metadata file which has the same name and
+// the extension ".meta.json". Since archiving is only a debugging aid, any
+// errors that occur are passed to t.Log instead of failing the test.
+func (r *Response) archive() {
+	t := r.recorder.t
+	dir := r.recorder.ResponseArchiveDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Log("Could not archive response: ", err)
+		return
+	}
+	file, err := ioutil.TempFile(dir, "response-*.body")
+	if err != nil {
+		t.Log("Could not archive response: ", err)
+		return
+	}
+	_, err = file.Write(r.RawBody)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Log("Could not archive response: ", err)
+		return
+	}
+	meta := archiveMetadata{
+		StatusCode: r.StatusCode,
+		Time:       r.startTime,
+	}
+	if r.Request != nil {
+		meta.Method = r.Request.Method
+		meta.URL = r.Request.URL.String()
+	}
+	data, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		t.Log("Could not archive response: ", err)
+		return
+	}
+	metaName := strings.TrimSuffix(file.Name(), ".body") + ".meta.json"
+	if err := ioutil.WriteFile(metaName, data, 0644); err != nil {
+		t.Log("Could not archive response: ", err)
+	}
+}
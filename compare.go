@@ -0,0 +1,143 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CompareOptions determines how two responses are compared.
+type CompareOptions struct {
+	// Headers are the names of the headers which must be equal in both
+	// responses. Other headers are not compared.
+	Headers []string
+	// IgnorePaths are dot-separated paths of JSON fields which are not
+	// compared, such as generated ids and timestamps. They are only used if
+	// both response bodies are JSON.
+	IgnorePaths []string
+}
+
+// ExpectSameAs causes a test error if the response is not the same as other.
+// The status codes, the headers listed in the CompareOptions option of the
+// recorder, and the bodies are compared. If both bodies are JSON, they are
+// compared structurally and any fields in CompareOptions.IgnorePaths are
+// skipped. All of the differences are reported in a single error.
+func (r *Response) ExpectSameAs(other *Response) {
+	r.subtest("ExpectSameAs", func() {
+		if diffs := compareResponses(other, r, r.recorder.CompareOptions); len(diffs) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected response to be the same as the other response but found differences:\n%s", strings.Join(diffs, "\n"))
+		}
+	})
+}
+
+// CompareResponses causes a test error if b is not the same as a according to
+// opts, and returns a description of each difference, or nil if there are
+// none. The status codes, the headers listed in opts.Headers, and the bodies
+// are compared in the same way as ExpectSameAs, with bodies which are not JSON
+// compared line by line. All of the differences are reported in a single error
+// via the recorder of b. Unlike ExpectSameAs, a and b may have been recorded by
+// different recorders, which is useful for checking that a new implementation
+// of an API behaves the same as an old one.
+func CompareResponses(a, b *Response, opts CompareOptions) []string {
+	var diffs []string
+	b.subtest("CompareResponses", func() {
+		if diffs = compareResponses(a, b, opts); len(diffs) == 0 {
+			diffs = nil
+			return
+		}
+		b.PrintFailureOnce()
+		b.errorf("Expected %s request to %s to have the same response as %s request to %s but found differences:\n%s",
+			b.Request.Method,
+			b.Request.URL,
+			a.Request.Method,
+			a.Request.URL,
+			strings.Join(diffs, "\n"))
+	})
+	return diffs
+}
+
+// compareResponses returns a description of each difference between
+// expected and actual according to opts.
+func compareResponses(expected, actual *Response, opts CompareOptions) []string {
+	diffs := []string{}
+	if expected.StatusCode != actual.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status code: expected %d but got %d", expected.StatusCode, actual.StatusCode))
+	}
+	for _, name := range opts.Headers {
+		if e, a := expected.Header.Get(name), actual.Header.Get(name); e != a {
+			diffs = append(diffs, fmt.Sprintf("header %s: expected %q but got %q", name, e, a))
+		}
+	}
+	var expectedJSON, actualJSON interface{}
+	if isJSONContentType(expected.Header.Get("Content-Type")) &&
+		isJSONContentType(actual.Header.Get("Content-Type")) &&
+		json.Unmarshal(expected.RawBody, &expectedJSON) == nil &&
+		json.Unmarshal(actual.RawBody, &actualJSON) == nil {
+		for _, diff := range jsonDiff("", expectedJSON, actualJSON, jsonPathSet(opts.IgnorePaths)) {
+			diffs = append(diffs, "body "+diff)
+		}
+	} else if e, a := string(expected.Body), string(actual.Body); e != a {
+		diffs = append(diffs, "body:\n"+unifiedDiff(e, a))
+	}
+	return diffs
+}
+
+// ExpectIdempotentPut sends the same PUT request to the given path twice,
+// using data as parameters, and causes a test error if the two responses are
+// not the same. The responses are compared in the same way as ExpectSameAs,
+// so any dynamic fields (e.g. an updated_at timestamp) can be skipped via
+// r.CompareOptions. The second response is returned. Any errors that occur
+// will be passed to t.Fatal
+func (r *Recorder) ExpectIdempotentPut(path string, data map[string]string) *Response {
+	first := r.Put(path, data)
+	second := r.Put(path, data)
+	second.subtest("ExpectIdempotentPut", func() {
+		if diffs := compareResponses(first, second, r.CompareOptions); len(diffs) > 0 {
+			second.PrintFailureOnce()
+			second.errorf("Expected repeated PUT request to %s to have the same response but found differences:\n%s", path, strings.Join(diffs, "\n"))
+		}
+	})
+	return second
+}
+
+// headMatchesGetHeaders are the headers compared by ExpectHeadMatchesGet.
+var headMatchesGetHeaders = []string{"Content-Type", "Content-Length", "ETag", "Last-Modified", "Cache-Control"}
+
+// ExpectHeadMatchesGet sends a HEAD request and a GET request to the given
+// path and causes a test error if the HEAD response has a body or differs
+// from the GET response in its status code or in any of the Content-Type,
+// Content-Length, ETag, Last-Modified, and Cache-Control headers. If the GET
+// response was transparently decompressed, Content-Length is not compared.
+// The HEAD response is returned. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) ExpectHeadMatchesGet(path string) *Response {
+	head := r.Do(r.NewRequest("HEAD", path))
+	get := r.Get(path)
+	head.subtest("ExpectHeadMatchesGet", func() {
+		diffs := []string{}
+		if head.StatusCode != get.StatusCode {
+			diffs = append(diffs, fmt.Sprintf("status code: GET was %d but HEAD was %d", get.StatusCode, head.StatusCode))
+		}
+		for _, name := range headMatchesGetHeaders {
+			if name == "Content-Length" && get.Uncompressed {
+				continue
+			}
+			if g, h := get.Header.Get(name), head.Header.Get(name); g != h {
+				diffs = append(diffs, fmt.Sprintf("header %s: GET was %q but HEAD was %q", name, g, h))
+			}
+		}
+		if len(head.RawBody) != 0 {
+			diffs = append(diffs, fmt.Sprintf("body: HEAD response had a body of %d bytes", len(head.RawBody)))
+		}
+		if len(diffs) > 0 {
+			head.PrintFailureOnce()
+			head.errorf("Expected HEAD response for %s to match GET response but found differences:\n%s", path, strings.Join(diffs, "\n"))
+		}
+	})
+	return head
+}
@@ -0,0 +1,84 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// versionedHandler responds with a JSON user whose id and X-Version header
+// differ between versions, and whose name is given by the path.
+func versionedHandler(version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Version", version)
+		w.Header().Set("Cache-Control", "no-store")
+		name := strings.TrimPrefix(req.URL.Path, "/")
+		w.Write([]byte(`{"id":"` + version + `-id","name":"` + name + `","roles":["admin"]}`))
+	})
+}
+
+func TestCompareResponses(t *testing.T) {
+	legacy := NewRecorder(t, versionedHandler("v1"))
+	defer legacy.Close()
+	current := NewRecorder(t, versionedHandler("v2"))
+	defer current.Close()
+	opts := CompareOptions{
+		Headers:     []string{"Content-Type", "Cache-Control"},
+		IgnorePaths: []string{"id"},
+	}
+
+	if diffs := CompareResponses(legacy.Get("/alice"), current.Get("/alice"), opts); diffs != nil {
+		t.Errorf("Expected no differences but got %q", diffs)
+	}
+
+	testCases := []struct {
+		a, b     *Response
+		opts     CompareOptions
+		expected []string
+	}{
+		{legacy.Get("/alice"), current.Get("/alice"), CompareOptions{Headers: []string{"Content-Type"}}, []string{"body `id`: expected \"v1-id\" but got \"v2-id\""}},
+		{legacy.Get("/alice"), current.Get("/alice"), CompareOptions{Headers: []string{"X-Version"}, IgnorePaths: []string{"id"}}, []string{"header X-Version: expected \"v1\" but got \"v2\""}},
+		{legacy.Get("/alice"), current.Get("/bob"), opts, []string{"body `name`: expected \"alice\" but got \"bob\""}},
+	}
+	for _, tc := range testCases {
+		var diffs []string
+		if !failed(func(t *testing.T) {
+			current.t = t
+			diffs = CompareResponses(tc.a, tc.b, tc.opts)
+		}) {
+			t.Errorf("Expected CompareResponses to fail for %s and %s", tc.a.Request.URL.Path, tc.b.Request.URL.Path)
+		}
+		current.t = t
+		if !reflect.DeepEqual(diffs, tc.expected) {
+			t.Errorf("Expected differences %q but got %q", tc.expected, diffs)
+		}
+	}
+}
+
+func TestCompareResponsesText(t *testing.T) {
+	a := NewRecorder(t, bodyHandler("line one\nline two\n"))
+	defer a.Close()
+	b := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("line one\nline 2\n"))
+	}))
+	defer b.Close()
+
+	var diffs []string
+	if !failed(func(t *testing.T) {
+		b.t = t
+		diffs = CompareResponses(a.Get("/"), b.Get("/"), CompareOptions{})
+	}) {
+		t.Error("Expected CompareResponses to fail for different text bodies")
+	}
+	if len(diffs) != 2 || diffs[0] != "status code: expected 200 but got 201" ||
+		!strings.Contains(diffs[1], "-line two") || !strings.Contains(diffs[1], "+line 2") {
+		t.Errorf("Expected status code and body differences but got %q", diffs)
+	}
+}
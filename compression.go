@@ -0,0 +1,143 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// compressionTransport is the http.RoundTripper used by a recorder. It
+// performs the same transparent gzip compression as http.Transport, but it
+// also counts the number of compressed bytes received so that the size of the
+// response on the wire can be reported.
+type compressionTransport struct {
+	transport *http.Transport
+}
+
+// RoundTrip implements http.RoundTripper. If compression has not been
+// disabled and req does not have its own Accept-Encoding header, a gzip
+// response is requested and decompressed before it is returned, exactly as
+// http.Transport would do.
+func (c *compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.transport.DisableCompression ||
+		req.Header.Get("Accept-Encoding") != "" ||
+		req.Header.Get("Range") != "" ||
+		req.Method == "HEAD" {
+		return c.transport.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		resp.Body = &gzipBody{wire: &countingReader{reader: resp.Body}, body: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return resp, nil
+}
+
+// countingReader counts the number of bytes read from reader.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// gzipBody decompresses a gzip-encoded response body as it is read. The
+// gzip reader is created lazily so that an empty body does not cause an error
+// until it is read.
+type gzipBody struct {
+	wire *countingReader
+	body io.Closer
+	gzip *gzip.Reader
+	err  error
+}
+
+// Read implements io.Reader.
+func (g *gzipBody) Read(p []byte) (int, error) {
+	if g.err != nil {
+		return 0, g.err
+	}
+	if g.gzip == nil {
+		if g.gzip, g.err = gzip.NewReader(g.wire); g.err != nil {
+			return 0, g.err
+		}
+	}
+	return g.gzip.Read(p)
+}
+
+// Close implements io.Closer.
+func (g *gzipBody) Close() error {
+	return g.body.Close()
+}
+
+// CompressedSize returns the number of bytes in the body of the response as it
+// was sent over the wire. If the response was not compressed, this is the same
+// as DecompressedSize.
+func (r *Response) CompressedSize() int64 {
+	if r.wireSize != 0 {
+		return r.wireSize
+	}
+	return int64(len(r.RawBody))
+}
+
+// DecompressedSize returns the number of bytes in the body of the response
+// after it has been decompressed. Bodies are normally decompressed
+// transparently, in which case this is the length of RawBody. If compression
+// was disabled (see SetDisableCompression) and RawBody is still gzip-encoded,
+// it is decompressed to determine its size, and -1 is returned if that is not
+// possible.
+func (r *Response) DecompressedSize() int64 {
+	if !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return int64(len(r.RawBody))
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(r.RawBody))
+	if err != nil {
+		return -1
+	}
+	n, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// ExpectCompressed causes a test error if the response was not gzip-encoded
+// on the wire, or if the compressed body is not smaller than the decompressed
+// body.
+func (r *Response) ExpectCompressed() {
+	r.subtest("ExpectCompressed", func() {
+		r.expect(r.CheckCompressed())
+	})
+}
+
+// CheckCompressed is like ExpectCompressed but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckCompressed() (bool, string) {
+	if !r.Uncompressed && !strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		return false, "Expected response to be gzip-encoded but it was not."
+	}
+	if compressed, decompressed := r.CompressedSize(), r.DecompressedSize(); compressed >= decompressed {
+		return false, fmt.Sprintf("Expected compressed response body to be smaller than %d bytes but got: %d bytes", decompressed, compressed)
+	}
+	return true, ""
+}
@@ -0,0 +1,118 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// gzipHandler always responds with body, gzip-encoded, and records the
+// Accept-Encoding header of the last request in acceptEncoding.
+func gzipHandler(body string, acceptEncoding *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*acceptEncoding = req.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		writer := gzip.NewWriter(w)
+		writer.Write([]byte(body))
+		writer.Close()
+	})
+}
+
+// gzipBytes returns the gzip encoding of s.
+func gzipBytes(t *testing.T, s string) []byte {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTransparentCompression(t *testing.T) {
+	body := strings.Repeat("hello, compression! ", 100)
+	compressed := gzipBytes(t, body)
+	var acceptEncoding string
+	rec := NewRecorder(t, gzipHandler(body, &acceptEncoding))
+	defer rec.Close()
+
+	res := rec.Get("/")
+	if acceptEncoding != "gzip" {
+		t.Errorf("Expected Accept-Encoding to be gzip but got %q", acceptEncoding)
+	}
+	res.ExpectBodyEquals(body)
+	res.ExpectNoHeader("Content-Encoding")
+	res.ExpectCompressed()
+	if !res.Uncompressed {
+		t.Error("Expected Uncompressed to be true")
+	}
+	if size := res.CompressedSize(); size != int64(len(compressed)) {
+		t.Errorf("Expected CompressedSize to be %d but got %d", len(compressed), size)
+	}
+	if size := res.DecompressedSize(); size != int64(len(body)) {
+		t.Errorf("Expected DecompressedSize to be %d but got %d", len(body), size)
+	}
+}
+
+func TestDisableCompressionPassthrough(t *testing.T) {
+	body := strings.Repeat("hello, compression! ", 100)
+	compressed := gzipBytes(t, body)
+	var acceptEncoding string
+	rec := NewRecorder(t, gzipHandler(body, &acceptEncoding))
+	defer rec.Close()
+	rec.SetDisableCompression(true)
+
+	res := rec.Get("/")
+	if acceptEncoding != "" {
+		t.Errorf("Expected no Accept-Encoding header but got %q", acceptEncoding)
+	}
+	if !bytes.Equal(res.RawBody, compressed) {
+		t.Errorf("Expected RawBody to be the gzip-encoded body but got %q", res.RawBody)
+	}
+	if encoding := res.Header.Get("Content-Encoding"); encoding != "gzip" {
+		t.Errorf("Expected Content-Encoding to be gzip but got %q", encoding)
+	}
+	if res.Uncompressed {
+		t.Error("Expected Uncompressed to be false")
+	}
+	res.ExpectCompressed()
+	if size := res.CompressedSize(); size != int64(len(compressed)) {
+		t.Errorf("Expected CompressedSize to be %d but got %d", len(compressed), size)
+	}
+	if size := res.DecompressedSize(); size != int64(len(body)) {
+		t.Errorf("Expected DecompressedSize to be %d but got %d", len(body), size)
+	}
+
+	// A request with its own Accept-Encoding header is also passed through
+	// when compression is enabled.
+	rec.SetDisableCompression(false)
+	req := rec.NewRequest("GET", "/")
+	req.Header.Set("Accept-Encoding", "gzip")
+	if res := rec.Do(req); !bytes.Equal(res.RawBody, compressed) {
+		t.Errorf("Expected RawBody to be the gzip-encoded body but got %q", res.RawBody)
+	}
+}
+
+func TestExpectCompressedFailure(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler(strings.Repeat("plain ", 100)))
+	defer rec.Close()
+	res := rec.Get("/")
+	if size := res.CompressedSize(); size != res.DecompressedSize() {
+		t.Errorf("Expected CompressedSize to equal DecompressedSize for an uncompressed response but got %d", size)
+	}
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		res.ExpectCompressed()
+	}) {
+		t.Error("Expected ExpectCompressed to fail for an uncompressed response")
+	}
+}
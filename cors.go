@@ -0,0 +1,61 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Preflight sends a CORS preflight request to the given path, i.e. an OPTIONS
+// request with the Origin header set to origin and the
+// Access-Control-Request-Method header set to method. The response can be
+// checked with ExpectCORS. path will be appended to the baseURL for the
+// recorder to create the full URL. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) Preflight(path, origin, method string) *Response {
+	req := r.NewRequest("OPTIONS", path)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	return r.Do(req)
+}
+
+// ExpectCORS causes a test error if the Access-Control-Allow-Origin header of
+// the response is not origin or "*", or if the Access-Control-Allow-Methods
+// header does not include every one of the given methods. All of the problems
+// are reported in a single error.
+func (r *Response) ExpectCORS(origin string, methods []string) {
+	r.subtest(fmt.Sprintf("ExpectCORS(%s)", origin), func() {
+		r.expect(r.CheckCORS(origin, methods))
+	})
+}
+
+// CheckCORS is like ExpectCORS but returns the outcome and a failure message
+// instead of causing a test error.
+func (r *Response) CheckCORS(origin string, methods []string) (bool, string) {
+	problems := []string{}
+	switch allowOrigin := r.Header.Get("Access-Control-Allow-Origin"); allowOrigin {
+	case origin, "*":
+	case "":
+		problems = append(problems, "Access-Control-Allow-Origin is missing")
+	default:
+		problems = append(problems, fmt.Sprintf("Access-Control-Allow-Origin should be %q or \"*\" but got: %q", origin, allowOrigin))
+	}
+	allowed := map[string]bool{}
+	for _, value := range r.Header.Values("Access-Control-Allow-Methods") {
+		for _, method := range strings.Split(value, ",") {
+			allowed[strings.ToUpper(strings.TrimSpace(method))] = true
+		}
+	}
+	for _, method := range methods {
+		if !allowed[strings.ToUpper(method)] {
+			problems = append(problems, fmt.Sprintf("Access-Control-Allow-Methods should include %s but got: %q", method, strings.Join(r.Header.Values("Access-Control-Allow-Methods"), ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		return false, "Expected response to have CORS headers but:\n\t" + strings.Join(problems, "\n\t")
+	}
+	return true, ""
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/csv"
+	"fmt"
+)
+
+// readCSV parses the body of the response as CSV and returns all of the
+// records, including the header. Any errors that occur will be passed to
+// t.Fatal.
+func (r *Response) readCSV() [][]string {
+	records, err := csv.NewReader(r.Reader()).ReadAll()
+	if err != nil {
+		r.PrintFailureOnce()
+		r.recorder.t.Fatal(err)
+	}
+	return records
+}
+
+// ExpectCSVRowCount causes a test error if the response body, parsed as CSV,
+// does not have exactly n data rows. The first row is assumed to be the header
+// and is not counted.
+func (r *Response) ExpectCSVRowCount(n int) {
+	r.subtest(fmt.Sprintf("ExpectCSVRowCount(%d)", n), func() {
+		rows := len(r.readCSV()) - 1
+		if rows < 0 {
+			rows = 0
+		}
+		if rows != n {
+			r.PrintFailureOnce()
+			r.errorf("Expected CSV response to have %d data rows but got: %d", n, rows)
+		}
+	})
+}
+
+// ExpectCSVHeader causes a test error if the first row of the response body,
+// parsed as CSV, is not exactly the given columns (in order).
+func (r *Response) ExpectCSVHeader(columns ...string) {
+	r.subtest("ExpectCSVHeader", func() {
+		records := r.readCSV()
+		header := []string{}
+		if len(records) > 0 {
+			header = records[0]
+		}
+		if !stringSlicesEqual(header, columns) {
+			r.PrintFailureOnce()
+			r.errorf("Expected CSV header to be %q but got: %q", columns, header)
+		}
+	})
+}
+
+// stringSlicesEqual returns true iff a and b have the same elements in the
+// same order.
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change in
+// the output of unifiedDiff.
+const diffContext = 3
+
+// diffLine is a single line in a line-by-line diff. op is ' ' if the line is
+// unchanged, '-' if it was removed, and '+' if it was added.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns a line-by-line diff of expected and actual in the
+// unified diff format. Only the lines which differ are shown, along with a few
+// lines of context around them. An empty string is returned if expected and
+// actual are equal.
+func unifiedDiff(expected, actual string) string {
+	if expected == actual {
+		return ""
+	}
+	lines := diffLines(strings.Split(expected, "\n"), strings.Split(actual, "\n"))
+
+	// Keep track of the line numbers in expected and actual at the start of
+	// each diff line, so they can be included in the hunk headers.
+	expectedLineNums := make([]int, len(lines)+1)
+	actualLineNums := make([]int, len(lines)+1)
+	for i, line := range lines {
+		expectedLineNums[i+1] = expectedLineNums[i]
+		actualLineNums[i+1] = actualLineNums[i]
+		if line.op != '+' {
+			expectedLineNums[i+1]++
+		}
+		if line.op != '-' {
+			actualLineNums[i+1]++
+		}
+	}
+
+	out := &strings.Builder{}
+	out.WriteString("--- expected\n+++ actual\n")
+	i := 0
+	for {
+		// Find the next change
+		for i < len(lines) && lines[i].op == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		// Extend the hunk to include any nearby changes
+		end := i
+		for end < len(lines) {
+			if lines[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+
+		// Write the hunk, including the surrounding context
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		stop := end + diffContext
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+		fmt.Fprintf(out, "@@ -%d,%d +%d,%d @@\n",
+			expectedLineNums[start]+1,
+			expectedLineNums[stop]-expectedLineNums[start],
+			actualLineNums[start]+1,
+			actualLineNums[stop]-actualLineNums[start])
+		for _, line := range lines[start:stop] {
+			out.WriteByte(line.op)
+			out.WriteString(line.text)
+			out.WriteByte('\n')
+		}
+		i = stop
+	}
+	return out.String()
+}
+
+// diffLines returns the shortest sequence of unchanged, removed, and added
+// lines which transforms a into b. It uses the algorithm described in "An
+// O(ND) Difference Algorithm and Its Variations" by Eugene W. Myers.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds the values of v for k in [-d-1, d+1] before round d. It
+	// is used to walk back through the edit graph once the end is reached.
+	trace := [][]int{}
+	for d := 0; d <= max; d++ {
+		snapshot := make([]int, 2*d+3)
+		copy(snapshot, v[offset-d-1:offset+d+2])
+		trace = append(trace, snapshot)
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+	return nil
+}
+
+// backtrackDiff walks back through the edit graph using the trace produced by
+// diffLines and returns the resulting diff lines in order.
+func backtrackDiff(a, b []string, trace [][]int) []diffLine {
+	lines := []diffLine{}
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			lines = append(lines, diffLine{op: ' ', text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, diffLine{op: '+', text: b[y-1]})
+			} else {
+				lines = append(lines, diffLine{op: '-', text: a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	// The lines were added in reverse order
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjectedFault is the error returned for requests which fail because of
+// the FailureRate of a FaultConfig.
+var ErrInjectedFault = errors.New("fipple: injected fault")
+
+// FaultConfig determines which faults are injected into the requests sent by
+// a recorder. It can be used to check how an API or a test behaves when the
+// network is slow or unreliable. The zero value injects no faults.
+type FaultConfig struct {
+	// Latency is added before each request is sent. If the context of the
+	// request is canceled while waiting, the request fails with the error from
+	// the context.
+	Latency time.Duration
+	// FailureRate is the fraction of requests (between 0 and 1) which fail
+	// without being sent. Failed requests return ErrInjectedFault, which Do
+	// passes to t.Fatal, unless FailureCode is set.
+	FailureRate float64
+	// FailureCode, if set, causes failed requests to return a response with
+	// this code and an empty body instead of an error. This is useful in
+	// combination with DoWithBackoff or ExpectSuccessRate.
+	FailureCode int
+	// TruncateRate is the fraction of responses (between 0 and 1) whose
+	// bodies are cut short after TruncateAfter bytes. The truncated body ends
+	// normally, so the response can still be checked.
+	TruncateRate float64
+	// TruncateAfter is the number of bytes of the body which are kept when a
+	// response is truncated.
+	TruncateAfter int64
+	// Rand is the source of randomness used to decide which requests fail and
+	// which responses are truncated. It can be set to a source with a fixed
+	// seed to make the faults deterministic. If Rand is nil, the default
+	// source of the math/rand package is used.
+	Rand *rand.Rand
+}
+
+// faultRandMutex guards every use of the Rand of a FaultConfig. A single lock
+// is used because the same Rand is shared by a recorder and any recorders
+// returned by its Service method, and a *rand.Rand is not safe for concurrent
+// use.
+var faultRandMutex sync.Mutex
+
+// faultTransport is an http.RoundTripper which injects the faults described
+// by the Faults option of a recorder into requests sent with next.
+type faultTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+// chance returns true with the given probability.
+func (f *faultTransport) chance(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	faultRandMutex.Lock()
+	defer faultRandMutex.Unlock()
+	if src := f.recorder.Faults.Rand; src != nil {
+		return src.Float64() < probability
+	}
+	return rand.Float64() < probability
+}
+
+// RoundTrip implements http.RoundTripper.
+func (f *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	faults := f.recorder.Faults
+	if faults.Latency > 0 {
+		timer := time.NewTimer(faults.Latency)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+	if f.chance(faults.FailureRate) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if faults.FailureCode == 0 {
+			return nil, ErrInjectedFault
+		}
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", faults.FailureCode, http.StatusText(faults.FailureCode)),
+			StatusCode: faults.FailureCode,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("")),
+			Request:    req,
+		}, nil
+	}
+	resp, err := f.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if f.chance(faults.TruncateRate) {
+		resp.Body = &truncatedBody{
+			Reader: io.LimitReader(resp.Body, faults.TruncateAfter),
+			Closer: resp.Body,
+		}
+	}
+	return resp, nil
+}
+
+// truncatedBody is a response body which has been cut short.
+type truncatedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bodyHandler responds to every request with body.
+func bodyHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(body))
+	})
+}
+
+func TestFaultFailureCode(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler("ok"))
+	defer rec.Close()
+	rec.Faults = FaultConfig{FailureRate: 1, FailureCode: http.StatusServiceUnavailable}
+	rec.Get("/").ExpectCode(http.StatusServiceUnavailable)
+	rec.Faults = FaultConfig{}
+	rec.Get("/").ExpectBodyEquals("ok")
+}
+
+func TestFaultFailureError(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler("ok"))
+	defer rec.Close()
+	rec.Faults = FaultConfig{FailureRate: 1}
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Get("/")
+	}) {
+		t.Error("Expected an injected failure without a FailureCode to be passed to t.Fatal")
+	}
+}
+
+func TestFaultTruncateAndLatency(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler(strings.Repeat("x", 100)))
+	defer rec.Close()
+	rec.Faults = FaultConfig{
+		Latency:       20 * time.Millisecond,
+		TruncateRate:  1,
+		TruncateAfter: 10,
+		Rand:          rand.New(rand.NewSource(1)),
+	}
+	start := time.Now()
+	res := rec.Get("/")
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected request to take at least 20ms but took %s", elapsed)
+	}
+	if len(res.RawBody) != 10 {
+		t.Errorf("Expected truncated body to be 10 bytes but got %d", len(res.RawBody))
+	}
+}
+
+func TestFaultLatencyRespectsContext(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler("ok"))
+	defer rec.Close()
+	rec.Faults = FaultConfig{Latency: time.Minute}
+	rec.ExpectTimeout(rec.NewRequest("GET", "/"), 10*time.Millisecond)
+}
+
+func TestServiceFaults(t *testing.T) {
+	server := httptest.NewServer(bodyHandler("ok"))
+	defer server.Close()
+	rec := NewURLRecorder(t, server.URL)
+	rec.RegisterService("api", server.URL)
+	service := rec.Service("api")
+	service.Faults = FaultConfig{FailureRate: 1, FailureCode: http.StatusServiceUnavailable}
+	service.Get("/").ExpectCode(http.StatusServiceUnavailable)
+	// The faults of the service must not affect the parent recorder.
+	rec.Get("/").ExpectCode(http.StatusOK)
+}
+
+func TestServiceFaultsSharedRand(t *testing.T) {
+	handler := &concurrencyHandler{}
+	rec := NewRecorder(t, handler)
+	defer rec.Close()
+	rec.RegisterService("self", rec.server.URL)
+	// The Rand is shared by all the services, which use it concurrently. This
+	// is checked by the race detector.
+	rec.Faults = FaultConfig{
+		TruncateRate:  0.5,
+		TruncateAfter: 1,
+		Rand:          rand.New(rand.NewSource(1)),
+	}
+	sendConcurrently(rec, 8)
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// BodyFormatter converts a request or response body into a human-readable
+// form, for use in failure output.
+type BodyFormatter func(body []byte) string
+
+// DefaultBodyFormatters are the body formatters used by a recorder unless the
+// BodyFormatters option is changed. They are keyed by media type (i.e. the
+// Content-Type without any parameters).
+var DefaultBodyFormatters = map[string]BodyFormatter{
+	"application/x-www-form-urlencoded": FormatFormBody,
+}
+
+// FormatFormBody formats a body encoded as application/x-www-form-urlencoded
+// as one "key: value" pair per line, sorted by key. If the body cannot be
+// parsed, it is returned unchanged.
+func FormatFormBody(body []byte) string {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return string(body)
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{}
+	for _, key := range keys {
+		for _, value := range values[key] {
+			lines = append(lines, fmt.Sprintf("%s: %s", key, value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatBody formats body using the formatter for contentType, if there is
+// one. The second return value is false if there is no such formatter.
+func (r *Recorder) formatBody(contentType string, body []byte) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	formatter, found := r.BodyFormatters[mediaType]
+	if !found {
+		return "", false
+	}
+	return formatter(body), true
+}
+
+// formattedBody returns the body of the response in a human-readable form.
+func (r *Response) formattedBody() string {
+	if formatted, ok := r.recorder.formatBody(r.Header.Get("Content-Type"), r.RawBody); ok {
+		return formatted
+	}
+	return string(r.Body)
+}
+
+// formattedRequestBody returns the body of the request in a human-readable
+// form, or an empty string if the request had no body or the body cannot be
+// read again.
+func (r *Response) formattedRequestBody() string {
+	if r.Request == nil || r.Request.GetBody == nil {
+		return ""
+	}
+	reader, err := r.Request.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return ""
+	}
+	if formatted, ok := r.recorder.formatBody(r.Request.Header.Get("Content-Type"), body); ok {
+		return formatted
+	}
+	return string(body)
+}
@@ -0,0 +1,190 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// requestIDHeader is the header used to match what happened in the handler of
+// a recorder (e.g. a panic) to the request which caused it. It is only added
+// to the copy of the request sent by tagTransport, and it is removed before the
+// request reaches the handler.
+const requestIDHeader = "X-Fipple-Request-Id"
+
+// HandlerPanic describes a panic which occurred in the handler of a recorder
+// while it was handling a request. See Recorder.RecoverHandler.
+type HandlerPanic struct {
+	// Value is the value which was passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine which panicked.
+	Stack []byte
+}
+
+// handlerLog holds what happened in the handler of a recorder while it was
+// handling each request, keyed by the id of the request.
+type handlerLog struct {
+	sync.Mutex
+	nextID   int
+	inFlight map[string]bool
+	panics   map[string]*HandlerPanic
+	logs     map[string][]string
+}
+
+// newHandlerLog returns an empty handlerLog.
+func newHandlerLog() *handlerLog {
+	return &handlerLog{
+		inFlight: map[string]bool{},
+		panics:   map[string]*HandlerPanic{},
+		logs:     map[string][]string{},
+	}
+}
+
+// newID returns a new unique request id.
+func (l *handlerLog) newID() string {
+	l.Lock()
+	defer l.Unlock()
+	l.nextID++
+	return strconv.Itoa(l.nextID)
+}
+
+// start marks the request with the given id as being handled.
+func (l *handlerLog) start(id string) {
+	l.Lock()
+	defer l.Unlock()
+	l.inFlight[id] = true
+}
+
+// finish marks the request with the given id as no longer being handled.
+func (l *handlerLog) finish(id string) {
+	l.Lock()
+	defer l.Unlock()
+	delete(l.inFlight, id)
+}
+
+// addPanic records p as the panic caused by the request with the given id.
+func (l *handlerLog) addPanic(id string, p *HandlerPanic) {
+	l.Lock()
+	defer l.Unlock()
+	l.panics[id] = p
+}
+
+// Write implements io.Writer. Each line of p is recorded as a log line of
+// every request which is currently being handled.
+func (l *handlerLog) Write(p []byte) (int, error) {
+	lines := strings.Split(strings.TrimSuffix(string(p), "\n"), "\n")
+	l.Lock()
+	defer l.Unlock()
+	for id := range l.inFlight {
+		l.logs[id] = append(l.logs[id], lines...)
+	}
+	return len(p), nil
+}
+
+// take removes and returns the panic (or nil if there was none) and the log
+// lines for the request with the given id.
+func (l *handlerLog) take(id string) (*HandlerPanic, []string) {
+	l.Lock()
+	defer l.Unlock()
+	p, logs := l.panics[id], l.logs[id]
+	delete(l.panics, id)
+	delete(l.logs, id)
+	return p, logs
+}
+
+// ServerLog returns a writer which can be used as the log output of the
+// handler given to NewRecorder (e.g. via log.SetOutput or log.New). If
+// CaptureServerLogs is true, each line written to it while a request is being
+// handled is added to the ServerLogs of the resulting Response. If several
+// requests are being handled at the same time, the line is added to all of
+// them. Lines written at any other time are discarded.
+func (r *Recorder) ServerLog() io.Writer {
+	return r.handlerLog
+}
+
+// wrapHandler wraps handler so that the id added to each request by
+// tagTransport is removed before the request reaches handler, and so that logs
+// and panics can be recorded for the request. If r.RecoverHandler is true, any
+// panics are recovered and recorded along with their stack trace, and a 500
+// response is sent instead. Panics with http.ErrAbortHandler are not
+// recovered.
+func (r *Recorder) wrapHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		req.Header.Del(requestIDHeader)
+		if id == "" {
+			handler.ServeHTTP(w, req)
+			return
+		}
+		r.handlerLog.start(id)
+		defer r.handlerLog.finish(id)
+		if r.RecoverHandler {
+			defer func() {
+				if v := recover(); v != nil {
+					if v == http.ErrAbortHandler {
+						panic(v)
+					}
+					r.handlerLog.addPanic(id, &HandlerPanic{
+						Value: v,
+						Stack: debug.Stack(),
+					})
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// tagTransport is the outermost http.RoundTripper of the client of a
+// recorder. If r.RecoverHandler or r.CaptureServerLogs is true and r has a
+// handler, it sends a copy of each request with a unique id added, so that any
+// panics or logs the request causes can be matched to its response. The
+// request given to the recorder is never modified.
+type tagTransport struct {
+	recorder *Recorder
+	next     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *tagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := t.recorder
+	if !(r.RecoverHandler || r.CaptureServerLogs) || r.server == nil {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(requestIDHeader, r.handlerLog.newID())
+	return t.next.RoundTrip(req)
+}
+
+// recordHandlerLog sets resp.Panic and resp.ServerLogs according to what
+// happened in the handler while it was handling the request for resp (if
+// anything). If there was a panic, it is reported via t.Errorf, along with the
+// stack trace.
+func (r *Recorder) recordHandlerLog(resp *Response) {
+	if resp.Request == nil {
+		return
+	}
+	id := resp.Request.Header.Get(requestIDHeader)
+	if id == "" {
+		return
+	}
+	// resp.Request is the copy made by tagTransport, so the id can be removed
+	// without affecting the request that was sent.
+	resp.Request.Header.Del(requestIDHeader)
+	resp.Panic, resp.ServerLogs = r.handlerLog.take(id)
+	if resp.Panic != nil {
+		r.errorf("Handler panicked while handling %s request to %s: %v\n%s",
+			resp.Request.Method,
+			resp.Request.URL.Path,
+			resp.Panic.Value,
+			resp.Panic.Stack)
+	}
+}
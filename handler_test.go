@@ -0,0 +1,109 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newLoggingRecorder returns a recorder with CaptureServerLogs enabled whose
+// handler logs the path of each request, fails the test if the request id
+// reaches it, and panics if the path is /panic.
+func newLoggingRecorder(t *testing.T) *Recorder {
+	var rec *Recorder
+	rec = NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		logger := log.New(rec.ServerLog(), "", 0)
+		if id := req.Header.Get(requestIDHeader); id != "" {
+			t.Errorf("Expected %s header to be removed before reaching the handler but got %q", requestIDHeader, id)
+		}
+		logger.Printf("handling %s", req.URL.Path)
+		if req.URL.Path == "/panic" {
+			panic("something went wrong")
+		}
+		logger.Printf("done with %s", req.URL.Path)
+		w.Write([]byte("ok"))
+	}))
+	rec.CaptureServerLogs = true
+	return rec
+}
+
+func TestServerLogsCorrelation(t *testing.T) {
+	rec := newLoggingRecorder(t)
+	defer rec.Close()
+
+	req := rec.NewRequest("GET", "/a")
+	a := rec.Do(req)
+	b := rec.Get("/b")
+	if expected := []string{"handling /a", "done with /a"}; !reflect.DeepEqual(a.ServerLogs, expected) {
+		t.Errorf("Expected ServerLogs of /a to be %q but got %q", expected, a.ServerLogs)
+	}
+	if expected := []string{"handling /b", "done with /b"}; !reflect.DeepEqual(b.ServerLogs, expected) {
+		t.Errorf("Expected ServerLogs of /b to be %q but got %q", expected, b.ServerLogs)
+	}
+
+	// The request id must not leak into the request given to the recorder, the
+	// request recorded in the response, or the HAR output.
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		t.Errorf("Expected request given to Do not to have a %s header but got %q", requestIDHeader, id)
+	}
+	if id := a.Request.Header.Get(requestIDHeader); id != "" {
+		t.Errorf("Expected recorded request not to have a %s header but got %q", requestIDHeader, id)
+	}
+	har, err := rec.ToHAR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(har), requestIDHeader) {
+		t.Errorf("Expected HAR not to contain %s header but got:\n%s", requestIDHeader, har)
+	}
+
+	// Sending the same request again must not reuse the logs of the first.
+	again := rec.Do(req)
+	if expected := []string{"handling /a", "done with /a"}; !reflect.DeepEqual(again.ServerLogs, expected) {
+		t.Errorf("Expected ServerLogs of second /a to be %q but got %q", expected, again.ServerLogs)
+	}
+}
+
+func TestRecoverHandler(t *testing.T) {
+	rec := newLoggingRecorder(t)
+	defer rec.Close()
+	rec.RecoverHandler = true
+
+	var res *Response
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		res = rec.Get("/panic")
+	}) {
+		t.Error("Expected panic in handler to fail the test")
+	}
+	rec.t = t
+	res.ExpectCode(http.StatusInternalServerError)
+	if res.Panic == nil {
+		t.Fatal("Expected Panic to be set for /panic but it was nil")
+	}
+	if res.Panic.Value != "something went wrong" {
+		t.Errorf("Expected Panic.Value to be %q but got %v", "something went wrong", res.Panic.Value)
+	}
+	if !strings.Contains(string(res.Panic.Stack), "newLoggingRecorder") {
+		t.Errorf("Expected Panic.Stack to include the handler but got:\n%s", res.Panic.Stack)
+	}
+	if expected := []string{"handling /panic"}; !reflect.DeepEqual(res.ServerLogs, expected) {
+		t.Errorf("Expected ServerLogs of /panic to be %q but got %q", expected, res.ServerLogs)
+	}
+
+	// The panic must only be reported for the request which caused it.
+	ok := rec.Get("/ok")
+	ok.ExpectOk()
+	if ok.Panic != nil {
+		t.Errorf("Expected Panic to be nil for /ok but got %v", ok.Panic.Value)
+	}
+	if expected := []string{"handling /ok", "done with /ok"}; !reflect.DeepEqual(ok.ServerLogs, expected) {
+		t.Errorf("Expected ServerLogs of /ok to be %q but got %q", expected, ok.ServerLogs)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// The following types correspond to the objects in version 1.2 of the HTTP
+// Archive (HAR) format. See http://www.softwareishard.com/blog/har-12-spec/
+// for the full specification.
+
+type harLog struct {
+	Log harLogBody `json:"log"`
+}
+
+type harLogBody struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// ToHAR returns the request and response as an HTTP Archive (HAR) log with a
+// single entry. The result can be saved to a file and opened with browser
+// developer tools or any other HAR viewer. The body of the request is only
+// included if it can be read again after the request was sent, which is true
+// for all the requests created by the recorder except for streaming multipart
+// requests.
+func (r *Response) ToHAR() ([]byte, error) {
+	entry, err := r.harEntry()
+	if err != nil {
+		return nil, err
+	}
+	return marshalHAR([]harEntry{entry})
+}
+
+// ToHAR returns every response in r.History as an HTTP Archive (HAR) log. See
+// Response.ToHAR for more information.
+func (r *Recorder) ToHAR() ([]byte, error) {
+	entries := []harEntry{}
+	for _, resp := range r.History {
+		entry, err := resp.harEntry()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return marshalHAR(entries)
+}
+
+// marshalHAR returns a HAR log with the given entries encoded as JSON.
+func marshalHAR(entries []harEntry) ([]byte, error) {
+	return json.MarshalIndent(harLog{
+		Log: harLogBody{
+			Version: "1.2",
+			Creator: harCreator{Name: "fipple", Version: "0"},
+			Entries: entries,
+		},
+	}, "", "\t")
+}
+
+// harEntry converts the request and response into a HAR entry.
+func (r *Response) harEntry() (harEntry, error) {
+	req := r.Request
+	reqBody := []byte{}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return harEntry{}, err
+		}
+		reqBody, err = ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return harEntry{}, err
+		}
+	}
+	entry := harEntry{
+		StartedDateTime: r.startTime.Format(time.RFC3339Nano),
+		Time:            harMillis(r.duration),
+		Request: harRequest{
+			Method:      req.Method,
+			URL:         req.URL.String(),
+			HTTPVersion: req.Proto,
+			Cookies:     harCookies(req.Cookies()),
+			Headers:     harHeaders(req.Header),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(reqBody),
+		},
+		Response: harResponse{
+			Status:      r.StatusCode,
+			StatusText:  http.StatusText(r.StatusCode),
+			HTTPVersion: r.Proto,
+			Cookies:     harCookies(r.Cookies()),
+			Headers:     harHeaders(r.Header),
+			Content:     harBodyContent(r.RawBody, r.Header.Get("Content-Type")),
+			RedirectURL: r.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(r.RawBody),
+		},
+		Timings: harTimings{Wait: harMillis(r.duration)},
+	}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: key, Value: value})
+		}
+	}
+	if len(reqBody) > 0 {
+		entry.Request.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     string(reqBody),
+		}
+	}
+	return entry, nil
+}
+
+// harMillis converts d to a number of milliseconds.
+func harMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// harHeaders converts header into a list of HAR name-value pairs. The
+// internal requestIDHeader is omitted.
+func harHeaders(header http.Header) []harNameValue {
+	pairs := []harNameValue{}
+	for key, values := range header {
+		if key == requestIDHeader {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, harNameValue{Name: key, Value: value})
+		}
+	}
+	return pairs
+}
+
+// harCookies converts cookies into a list of HAR name-value pairs.
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	pairs := []harNameValue{}
+	for _, cookie := range cookies {
+		pairs = append(pairs, harNameValue{Name: cookie.Name, Value: cookie.Value})
+	}
+	return pairs
+}
+
+// harBodyContent converts body into HAR content. Bodies which are not valid
+// UTF-8 are base64 encoded.
+func harBodyContent(body []byte, mimeType string) harContent {
+	content := harContent{
+		Size:     len(body),
+		MimeType: mimeType,
+	}
+	if utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
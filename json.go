@@ -0,0 +1,865 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// decodeJSON decodes the body of the response into a generic value (i.e.
+// the same types used by json.Unmarshal when decoding into an interface{}).
+// If r.recorder.StrictJSON is true and the Content-Type of the response is not
+// JSON, the body is not decoded. Any errors that occur will be passed to
+// t.Fatal.
+func (r *Response) decodeJSON() interface{} {
+	r.checkJSONContentType()
+	var v interface{}
+	if err := json.Unmarshal(r.RawBody, &v); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	return v
+}
+
+// decodeJSONNumbers is like decodeJSON, but numbers are decoded as
+// json.Number instead of float64, so that no precision is lost.
+func (r *Response) decodeJSONNumbers() interface{} {
+	r.checkJSONContentType()
+	decoder := json.NewDecoder(bytes.NewReader(r.RawBody))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	return v
+}
+
+// checkJSONContentType passes a clear message to t.Fatal if
+// r.recorder.StrictJSON is true and the Content-Type of the response is not
+// JSON.
+func (r *Response) checkJSONContentType() {
+	if r.recorder.StrictJSON && !isJSONContentType(r.Header.Get("Content-Type")) {
+		r.PrintFailureOnce()
+		r.recorder.t.Fatalf("Expected JSON response but Content-Type was %q", r.Header.Get("Content-Type"))
+	}
+}
+
+// isJSONContentType returns true iff contentType is application/json or a
+// structured syntax suffix type such as application/hal+json.
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonField decodes the body of the response and returns the value at the
+// given dot-separated path. Object keys and array indexes are both written as
+// path segments, e.g. "users.0.email". An empty path refers to the top-level
+// value. If there is no value at path, the second return value is false. Any
+// errors that occur while decoding will be passed to t.Fatal.
+func (r *Response) jsonField(path string) (interface{}, bool) {
+	return lookupJSONPath(r.decodeJSON(), path)
+}
+
+// expectJSONField is like jsonField but causes a test error if there is no
+// value at the given path.
+func (r *Response) expectJSONField(path string) (interface{}, bool) {
+	v, found := r.jsonField(path)
+	if !found {
+		r.PrintFailureOnce()
+		r.errorf("Expected JSON field %s to exist but it did not.", jsonPathName(path))
+	}
+	return v, found
+}
+
+// lookupJSONPath returns the value under v at the given dot-separated path.
+// If there is no value at path, the second return value is false.
+func lookupJSONPath(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, segment := range strings.Split(path, ".") {
+		switch typed := v.(type) {
+		case map[string]interface{}:
+			value, found := typed[segment]
+			if !found {
+				return nil, false
+			}
+			v = value
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(typed) {
+				return nil, false
+			}
+			v = typed[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// jsonPathName returns a human-readable name for path, suitable for use in
+// error messages.
+func jsonPathName(path string) string {
+	if path == "" {
+		return "at the top level"
+	}
+	return "`" + path + "`"
+}
+
+// jsonTypeName returns the name of the JSON type of v, which should be a value
+// decoded by json.Unmarshal into an interface{}.
+func jsonTypeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// ExpectJSONObjectKeys causes a test error if the JSON value at the given
+// dot-separated path is not an object with exactly the given keys. Both
+// missing keys and unexpected keys are reported. An empty path refers to the
+// top-level value.
+func (r *Response) ExpectJSONObjectKeys(path string, keys ...string) {
+	r.subtest(fmt.Sprintf("ExpectJSONObjectKeys(%s)", path), func() {
+		v, found := r.expectJSONField(path)
+		if !found {
+			return
+		}
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be an object but got: %s", jsonPathName(path), jsonTypeName(v))
+			return
+		}
+		expected := map[string]bool{}
+		missing := []string{}
+		for _, key := range keys {
+			expected[key] = true
+			if _, found := obj[key]; !found {
+				missing = append(missing, key)
+			}
+		}
+		unexpected := []string{}
+		for key := range obj {
+			if !expected[key] {
+				unexpected = append(unexpected, key)
+			}
+		}
+		sort.Strings(unexpected)
+		if len(missing) > 0 || len(unexpected) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON object %s to have keys %v but was missing: %v and had unexpected: %v",
+				jsonPathName(path),
+				keys,
+				missing,
+				unexpected)
+		}
+	})
+}
+
+// expectJSONNumber is like expectJSONField but also causes a test error if
+// the value at the given path is not a number.
+func (r *Response) expectJSONNumber(path string) (float64, bool) {
+	v, found := r.expectJSONField(path)
+	if !found {
+		return 0, false
+	}
+	n, ok := v.(float64)
+	if !ok {
+		r.PrintFailureOnce()
+		r.errorf("Expected JSON field %s to be a number but got: %s", jsonPathName(path), jsonTypeName(v))
+		return 0, false
+	}
+	return n, true
+}
+
+// ExpectJSONFieldInRange causes a test error if the JSON value at the given
+// dot-separated path is not a number between min and max (inclusive).
+func (r *Response) ExpectJSONFieldInRange(path string, min, max float64) {
+	r.subtest(fmt.Sprintf("ExpectJSONFieldInRange(%s)", path), func() {
+		n, ok := r.expectJSONNumber(path)
+		if !ok {
+			return
+		}
+		if n < min || n > max {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be between %v and %v but got: %v", jsonPathName(path), min, max, n)
+		}
+	})
+}
+
+// IsJSONObject returns true iff the top-level value of the response body is a
+// JSON object. Any errors that occur while decoding the body will be passed to
+// t.Fatal.
+func (r *Response) IsJSONObject() bool {
+	_, ok := r.decodeJSON().(map[string]interface{})
+	return ok
+}
+
+// IsJSONArray returns true iff the top-level value of the response body is a
+// JSON array. Any errors that occur while decoding the body will be passed to
+// t.Fatal.
+func (r *Response) IsJSONArray() bool {
+	_, ok := r.decodeJSON().([]interface{})
+	return ok
+}
+
+// ExpectJSONMatching causes a test error if the response body is not
+// equivalent to expected when both are encoded as JSON. Any values at the
+// given dot-separated ignorePaths are skipped, which is useful for fields such
+// as generated ids and timestamps which are different in every response. All
+// differences are reported in a single error. Any errors that occur while
+// encoding expected or decoding the body will be passed to t.Fatal.
+func (r *Response) ExpectJSONMatching(expected interface{}, ignorePaths ...string) {
+	r.subtest("ExpectJSONMatching", func() {
+		diffs := jsonDiff("", r.normalizeJSON(expected), r.decodeJSON(), jsonPathSet(ignorePaths))
+		if len(diffs) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON response to match but found differences:\n%s", strings.Join(diffs, "\n"))
+		}
+	})
+}
+
+// ExpectBodyEqualsJSON causes a test error if the response body and expected,
+// which must be a JSON document, are not structurally equal when both are
+// decoded. Unlike ExpectBodyEquals, differences in formatting and key order
+// are ignored. The body is decoded regardless of its Content-Type (and of the
+// StrictJSON option of the recorder), since the body is being compared to JSON
+// explicitly. All differences are reported in a single error. Any errors that
+// occur while decoding expected or the body will be passed to t.Fatal.
+func (r *Response) ExpectBodyEqualsJSON(expected string) {
+	r.subtest("ExpectBodyEqualsJSON", func() {
+		var e, actual interface{}
+		if err := json.Unmarshal([]byte(expected), &e); err != nil {
+			r.recorder.t.Fatalf("Could not decode expected JSON: %s", err)
+		}
+		if err := json.Unmarshal(r.RawBody, &actual); err != nil {
+			r.PrintFailureOnce()
+			r.recorder.t.Fatalf("Could not decode response body as JSON: %s", err)
+		}
+		if diffs := jsonDiff("", e, actual, nil); len(diffs) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON response to equal the given JSON but found differences:\n%s", strings.Join(diffs, "\n"))
+		}
+	})
+}
+
+// normalizeJSON converts v into the generic representation used by
+// json.Unmarshal when decoding into an interface{}, so that it can be compared
+// to a decoded response body. Any errors that occur will be passed to t.Fatal.
+func (r *Response) normalizeJSON(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	return normalized
+}
+
+// jsonPathSet converts paths into a set.
+func jsonPathSet(paths []string) map[string]bool {
+	set := map[string]bool{}
+	for _, path := range paths {
+		set[path] = true
+	}
+	return set
+}
+
+// joinJSONPath returns the dot-separated path for the child of path with the
+// given key or index.
+func joinJSONPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// jsonDiff returns a description of each difference between the generic JSON
+// values expected and actual, which are located at the given path. Values at
+// any of the paths in ignore are skipped.
+func jsonDiff(path string, expected, actual interface{}, ignore map[string]bool) []string {
+	if ignore[path] {
+		return nil
+	}
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range e {
+			keys = append(keys, key)
+		}
+		for key := range a {
+			if _, found := e[key]; !found {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		diffs := []string{}
+		for _, key := range keys {
+			childPath := joinJSONPath(path, key)
+			expectedValue, expectedFound := e[key]
+			actualValue, actualFound := a[key]
+			switch {
+			case ignore[childPath]:
+			case !actualFound:
+				diffs = append(diffs, fmt.Sprintf("%s: missing (expected %s)", jsonPathName(childPath), jsonString(expectedValue)))
+			case !expectedFound:
+				diffs = append(diffs, fmt.Sprintf("%s: unexpected (got %s)", jsonPathName(childPath), jsonString(actualValue)))
+			default:
+				diffs = append(diffs, jsonDiff(childPath, expectedValue, actualValue, ignore)...)
+			}
+		}
+		return diffs
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		if len(e) != len(a) {
+			return []string{fmt.Sprintf("%s: expected array of length %d but got length %d", jsonPathName(path), len(e), len(a))}
+		}
+		diffs := []string{}
+		for i := range e {
+			diffs = append(diffs, jsonDiff(joinJSONPath(path, strconv.Itoa(i)), e[i], a[i], ignore)...)
+		}
+		return diffs
+	default:
+		if expected == actual {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("%s: expected %s but got %s", jsonPathName(path), jsonString(expected), jsonString(actual))}
+}
+
+// jsonString returns v encoded as compact JSON, for use in error messages.
+func jsonString(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// expectJSONString is like expectJSONField but also causes a test error if
+// the value at the given path is not a string.
+func (r *Response) expectJSONString(path string) (string, bool) {
+	v, found := r.expectJSONField(path)
+	if !found {
+		return "", false
+	}
+	str, ok := v.(string)
+	if !ok {
+		r.PrintFailureOnce()
+		r.errorf("Expected JSON field %s to be a string but got: %s", jsonPathName(path), jsonTypeName(v))
+		return "", false
+	}
+	return str, true
+}
+
+// expectJSONTime is like expectJSONString but also causes a test error if the
+// value at the given path cannot be parsed as a time with the given layout.
+func (r *Response) expectJSONTime(path, layout string) (time.Time, bool) {
+	str, ok := r.expectJSONString(path)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(layout, str)
+	if err != nil {
+		r.PrintFailureOnce()
+		r.errorf("Expected JSON field %s to be a time with layout %q but got: %q", jsonPathName(path), layout, str)
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ExpectJSONFieldTime causes a test error if the JSON value at the given
+// dot-separated path is not a string which can be parsed by time.Parse with
+// the given layout. If layout is empty, time.RFC3339 is used.
+func (r *Response) ExpectJSONFieldTime(path, layout string) {
+	r.subtest(fmt.Sprintf("ExpectJSONFieldTime(%s)", path), func() {
+		if layout == "" {
+			layout = time.RFC3339
+		}
+		r.expectJSONTime(path, layout)
+	})
+}
+
+// ExpectJSONFieldTimeRecent causes a test error if the JSON value at the
+// given dot-separated path is not an RFC3339 time within the given duration of
+// the current time. This is useful for checking fields such as created_at.
+func (r *Response) ExpectJSONFieldTimeRecent(path string, within time.Duration) {
+	r.subtest(fmt.Sprintf("ExpectJSONFieldTimeRecent(%s)", path), func() {
+		t, ok := r.expectJSONTime(path, time.RFC3339)
+		if !ok {
+			return
+		}
+		diff := r.recorder.Now().Sub(t)
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > within {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be within %s of the current time but got: %s", jsonPathName(path), within, t.Format(time.RFC3339))
+		}
+	})
+}
+
+// ExpectJSONFieldBool causes a test error if the JSON value at the given
+// dot-separated path is not a boolean equal to expected. Unlike a generic
+// comparison, a string such as "true" is reported as having the wrong type.
+func (r *Response) ExpectJSONFieldBool(path string, expected bool) {
+	r.subtest(fmt.Sprintf("ExpectJSONFieldBool(%s)", path), func() {
+		v, found := r.expectJSONField(path)
+		if !found {
+			return
+		}
+		b, ok := v.(bool)
+		if !ok {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be a boolean but got %s: %s", jsonPathName(path), jsonTypeName(v), jsonString(v))
+			return
+		}
+		if b != expected {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be %t but got: %t", jsonPathName(path), expected, b)
+		}
+	})
+}
+
+// ExpectJSONFieldLength causes a test error if the length of the JSON value at
+// the given dot-separated path != n. The length of a string is the number of
+// characters (runes) it contains, the length of an array is the number of
+// elements, and the length of an object is the number of keys. Any other type
+// causes a test error.
+func (r *Response) ExpectJSONFieldLength(path string, n int) {
+	r.subtest(fmt.Sprintf("ExpectJSONFieldLength(%s)", path), func() {
+		v, found := r.expectJSONField(path)
+		if !found {
+			return
+		}
+		var length int
+		switch typed := v.(type) {
+		case string:
+			length = utf8.RuneCountInString(typed)
+		case []interface{}:
+			length = len(typed)
+		case map[string]interface{}:
+			length = len(typed)
+		default:
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be a string, array, or object but got: %s", jsonPathName(path), jsonTypeName(v))
+			return
+		}
+		if length != n {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON %s %s to have length %d but got: %d", jsonTypeName(v), jsonPathName(path), n, length)
+		}
+	})
+}
+
+// ExpectJSONArrayOf causes a test error if the JSON value at the given
+// dot-separated path is not an array of the given length in which every
+// element has the given type. elemType must be one of "null", "boolean",
+// "number", "string", "array", or "object". On failure, the first element
+// with the wrong type is reported. Any other elemType is passed to t.Fatal.
+func (r *Response) ExpectJSONArrayOf(path, elemType string, length int) {
+	switch elemType {
+	case "null", "boolean", "number", "string", "array", "object":
+	default:
+		r.recorder.t.Fatalf("Unknown JSON type %q", elemType)
+	}
+	r.subtest(fmt.Sprintf("ExpectJSONArrayOf(%s)", path), func() {
+		v, found := r.expectJSONField(path)
+		if !found {
+			return
+		}
+		array, ok := v.([]interface{})
+		if !ok {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be an array but got %s: %s", jsonPathName(path), jsonTypeName(v), jsonString(v))
+			return
+		}
+		if len(array) != length {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON array %s to have length %d but got: %d", jsonPathName(path), length, len(array))
+			return
+		}
+		for i, elem := range array {
+			if typeName := jsonTypeName(elem); typeName != elemType {
+				r.PrintFailureOnce()
+				r.errorf("Expected every element of JSON array %s to be a %s but element %d was %s: %s", jsonPathName(path), elemType, i, typeName, jsonString(elem))
+				return
+			}
+		}
+	})
+}
+
+// CanonicalJSON returns the body of the response in a canonical JSON form,
+// with object keys sorted and no insignificant whitespace. Numbers are kept
+// exactly as they appear in the body. This makes it possible to compute
+// stable hashes or signatures over responses that ignore key order and
+// formatting. If the StrictJSON option of the recorder is true and the
+// Content-Type of the response is not JSON, the body is not decoded. Any
+// errors that occur will be passed to t.Fatal.
+func (r *Response) CanonicalJSON() []byte {
+	r.checkJSONContentType()
+	decoder := json.NewDecoder(r.Reader())
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	buf := bytes.NewBuffer([]byte{})
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+// ExpectSchemaVersion causes a test error if the schema version of the JSON
+// response != expected. The version is read from the dot-separated path given
+// by the SchemaVersionPath option of the recorder ("version" by default), and
+// may be either a string or a number.
+func (r *Response) ExpectSchemaVersion(expected string) {
+	r.subtest(fmt.Sprintf("ExpectSchemaVersion(%s)", expected), func() {
+		path := r.recorder.SchemaVersionPath
+		v, found := r.expectJSONField(path)
+		if !found {
+			return
+		}
+		var version string
+		switch typed := v.(type) {
+		case string:
+			version = typed
+		case float64:
+			version = strconv.FormatFloat(typed, 'f', -1, 64)
+		default:
+			r.PrintFailureOnce()
+			r.errorf("Expected schema version %s to be a string or number but got: %s", jsonPathName(path), jsonTypeName(v))
+			return
+		}
+		if version != expected {
+			r.PrintFailureOnce()
+			r.errorf("Expected schema version %s to be %q but got: %q", jsonPathName(path), expected, version)
+		}
+	})
+}
+
+// ExpectEmptyJSONObject causes a test error if the response body is not an
+// empty JSON object (i.e. {}). Unlike ExpectBodyEquals, whitespace in the body
+// is ignored.
+func (r *Response) ExpectEmptyJSONObject() {
+	r.subtest("ExpectEmptyJSONObject", func() {
+		v := r.decodeJSON()
+		if obj, ok := v.(map[string]interface{}); !ok || len(obj) != 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected response to be an empty JSON object but got: %s", jsonString(v))
+		}
+	})
+}
+
+// ExpectEmptyJSONArray causes a test error if the response body is not an
+// empty JSON array (i.e. []). Unlike ExpectBodyEquals, whitespace in the body
+// is ignored.
+func (r *Response) ExpectEmptyJSONArray() {
+	r.subtest("ExpectEmptyJSONArray", func() {
+		v := r.decodeJSON()
+		if array, ok := v.([]interface{}); !ok || len(array) != 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected response to be an empty JSON array but got: %s", jsonString(v))
+		}
+	})
+}
+
+// DecodeJSONArray decodes the response body, which must be a JSON array, one
+// element at a time, calling fn with the raw JSON of each element in order.
+// Unlike Unmarshal, the array as a whole is never decoded, so only one element
+// needs to be held in decoded form at a time. If the StrictJSON option of the
+// recorder is true and the Content-Type of the response is not JSON, the body
+// is not decoded. Any errors that occur while decoding, or which are returned
+// by fn, will be passed to t.Fatal.
+func (r *Response) DecodeJSONArray(fn func(elem json.RawMessage) error) {
+	r.checkJSONContentType()
+	decoder := json.NewDecoder(r.Reader())
+	token, err := decoder.Token()
+	if err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		r.PrintFailureOnce()
+		r.recorder.t.Fatalf("Expected response to be a JSON array but got: %v", token)
+	}
+	for decoder.More() {
+		var elem json.RawMessage
+		if err := decoder.Decode(&elem); err != nil {
+			r.recorder.t.Fatal(err)
+		}
+		if err := fn(elem); err != nil {
+			r.recorder.t.Fatal(err)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+}
+
+// ExpectJSONField causes a test error if the JSON value at the given
+// dot-separated path is not equal to expected. If expected is a number of any
+// type (e.g. int, int64, or float64), it is compared to the JSON value by
+// mathematical value, so 5 is equal to 5.0 and large integers are compared
+// exactly, without the loss of precision caused by decoding them as float64.
+// Any other value is compared to the JSON value after both are converted to
+// the same form, as with ExpectJSONMatching.
+func (r *Response) ExpectJSONField(path string, expected interface{}) {
+	r.subtest(fmt.Sprintf("ExpectJSONField(%s)", path), func() {
+		expectedNum, isNum := numberRat(expected)
+		if !isNum {
+			v, found := r.expectJSONField(path)
+			if !found {
+				return
+			}
+			if diffs := jsonDiff(path, r.normalizeJSON(expected), v, nil); len(diffs) > 0 {
+				r.PrintFailureOnce()
+				r.errorf("Expected JSON field %s to be %s but got: %s", jsonPathName(path), jsonString(expected), jsonString(v))
+			}
+			return
+		}
+		v, found := lookupJSONPath(r.decodeJSONNumbers(), path)
+		if !found {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to exist but it did not.", jsonPathName(path))
+			return
+		}
+		n, ok := v.(json.Number)
+		if !ok {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be a number but got %s: %s", jsonPathName(path), jsonTypeName(v), jsonString(v))
+			return
+		}
+		actualNum, ok := new(big.Rat).SetString(n.String())
+		if !ok || actualNum.Cmp(expectedNum) != 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON field %s to be %v but got: %s", jsonPathName(path), expected, n)
+		}
+	})
+}
+
+// numberRat converts v to a *big.Rat if it is a number of any built-in
+// integer or floating point type (or a json.Number). Floating point numbers are
+// converted via their shortest decimal representation, so that e.g. 0.1 is
+// equal to the JSON number 0.1 rather than to the binary value of the float.
+// The second return value is false if v is not a number, or if it is NaN or
+// infinite.
+func numberRat(v interface{}) (*big.Rat, bool) {
+	switch n := v.(type) {
+	case int:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int8:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int16:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int32:
+		return new(big.Rat).SetInt64(int64(n)), true
+	case int64:
+		return new(big.Rat).SetInt64(n), true
+	case uint:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint8:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint16:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint32:
+		return new(big.Rat).SetUint64(uint64(n)), true
+	case uint64:
+		return new(big.Rat).SetUint64(n), true
+	case float32:
+		return new(big.Rat).SetString(strconv.FormatFloat(float64(n), 'g', -1, 32))
+	case float64:
+		return new(big.Rat).SetString(strconv.FormatFloat(n, 'g', -1, 64))
+	case json.Number:
+		return new(big.Rat).SetString(n.String())
+	}
+	return nil, false
+}
+
+// jsonKeyStyles holds the patterns which keys must match for each of the
+// styles supported by ExpectJSONKeysStyle.
+var jsonKeyStyles = map[string]*regexp.Regexp{
+	"snake": regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`),
+	"camel": regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`),
+}
+
+// ExpectJSONKeysStyle causes a test error if any key of any object in the
+// response body does not follow the given naming convention. style must be
+// either "snake" (e.g. "created_at") or "camel" (e.g. "createdAt"). Objects
+// nested inside other objects and arrays are checked too, and every offending
+// key is reported in a single error. Any other style is passed to t.Fatal.
+func (r *Response) ExpectJSONKeysStyle(style string) {
+	pattern, found := jsonKeyStyles[style]
+	if !found {
+		r.recorder.t.Fatalf("Unknown JSON key style %q", style)
+	}
+	r.subtest(fmt.Sprintf("ExpectJSONKeysStyle(%s)", style), func() {
+		offenders := jsonKeysNotMatching("", r.decodeJSON(), pattern)
+		if len(offenders) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected all JSON keys to be %s case but got:\n%s", style, strings.Join(offenders, "\n"))
+		}
+	})
+}
+
+// jsonKeysNotMatching returns the paths of all keys in the generic JSON value
+// v, which is located at the given path, which do not match pattern.
+func jsonKeysNotMatching(path string, v interface{}, pattern *regexp.Regexp) []string {
+	offenders := []string{}
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range typed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := joinJSONPath(path, key)
+			if !pattern.MatchString(key) {
+				offenders = append(offenders, jsonPathName(childPath))
+			}
+			offenders = append(offenders, jsonKeysNotMatching(childPath, typed[key], pattern)...)
+		}
+	case []interface{}:
+		for i, elem := range typed {
+			offenders = append(offenders, jsonKeysNotMatching(joinJSONPath(path, strconv.Itoa(i)), elem, pattern)...)
+		}
+	}
+	return offenders
+}
+
+// ExpectRequestIDConsistency causes a test error if the value of the header
+// with the given name is missing or is not equal to the JSON value at the given
+// dot-separated bodyPath. This is useful for values which must appear in both
+// places, such as a request id. If the JSON value is not a string, it is
+// compared in its JSON form (e.g. 42).
+func (r *Response) ExpectRequestIDConsistency(headerName, bodyPath string) {
+	r.subtest(fmt.Sprintf("ExpectRequestIDConsistency(%s)", headerName), func() {
+		v, found := r.expectJSONField(bodyPath)
+		if !found {
+			return
+		}
+		bodyValue, ok := v.(string)
+		if !ok {
+			bodyValue = jsonString(v)
+		}
+		headerValue := r.Header.Get(headerName)
+		if headerValue == "" || headerValue != bodyValue {
+			r.PrintFailureOnce()
+			r.errorf("Expected header %s to equal JSON field %s but the header was %q and the field was %q", headerName, jsonPathName(bodyPath), headerValue, bodyValue)
+		}
+	})
+}
+
+// ExpectJSONContainsFile causes a test error unless the response body contains
+// the JSON value in the file at the given path. Every key of every object in
+// the file must be present in the corresponding object of the response body
+// with a matching value, but the response body may have extra keys, which is
+// useful when only part of a response is stable. Arrays must have the same
+// length, and each element is matched in the same way. All differences are
+// reported in a single error. Any errors that occur while reading or decoding
+// the file or the body will be passed to t.Fatal.
+func (r *Response) ExpectJSONContainsFile(path string) {
+	r.subtest(fmt.Sprintf("ExpectJSONContainsFile(%s)", path), func() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			r.recorder.t.Fatal(err)
+		}
+		var expected interface{}
+		if err := json.Unmarshal(data, &expected); err != nil {
+			r.recorder.t.Fatalf("Could not decode JSON in %s: %s", path, err)
+		}
+		if diffs := jsonSubsetDiff("", expected, r.decodeJSON()); len(diffs) > 0 {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSON response to contain the contents of %s but found differences:\n%s", path, strings.Join(diffs, "\n"))
+		}
+	})
+}
+
+// jsonSubsetDiff is like jsonDiff, except that keys which are present in
+// actual but not in expected are not reported.
+func jsonSubsetDiff(path string, expected, actual interface{}) []string {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for key := range e {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		diffs := []string{}
+		for _, key := range keys {
+			childPath := joinJSONPath(path, key)
+			actualValue, found := a[key]
+			if !found {
+				diffs = append(diffs, fmt.Sprintf("%s: missing (expected %s)", jsonPathName(childPath), jsonString(e[key])))
+				continue
+			}
+			diffs = append(diffs, jsonSubsetDiff(childPath, e[key], actualValue)...)
+		}
+		return diffs
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !ok {
+			break
+		}
+		if len(e) != len(a) {
+			return []string{fmt.Sprintf("%s: expected array of length %d but got length %d", jsonPathName(path), len(e), len(a))}
+		}
+		diffs := []string{}
+		for i := range e {
+			diffs = append(diffs, jsonSubsetDiff(joinJSONPath(path, strconv.Itoa(i)), e[i], a[i])...)
+		}
+		return diffs
+	default:
+		if expected == actual {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("%s: expected %s but got %s", jsonPathName(path), jsonString(expected), jsonString(actual))}
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// jsonHandler responds to every request with body and a Content-Type of
+// application/json.
+func jsonHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+}
+
+func TestExpectJSONFieldNumbers(t *testing.T) {
+	rec := NewRecorder(t, jsonHandler(`{
+		"int": 1,
+		"float": 1.0,
+		"exp": 1e2,
+		"tenth": 0.1,
+		"sum": 0.30000000000000004,
+		"big": 9007199254740993,
+		"maxUint": 18446744073709551615,
+		"negative": -9223372036854775808,
+		"string": "1",
+		"nested": {"ids": [9007199254740993]}
+	}`))
+	defer rec.Close()
+	res := rec.Get("/")
+
+	passing := []struct {
+		path     string
+		expected interface{}
+	}{
+		{"int", 1},
+		{"int", 1.0},
+		{"int", int8(1)},
+		{"int", uint(1)},
+		{"int", float32(1)},
+		{"float", 1},
+		{"float", 1.0},
+		{"exp", 100},
+		{"tenth", 0.1},
+		{"tenth", float32(0.1)},
+		{"sum", math.Nextafter(0.3, 1)},
+		{"big", int64(9007199254740993)},
+		{"big", json.Number("9007199254740993")},
+		{"maxUint", uint64(math.MaxUint64)},
+		{"negative", int64(math.MinInt64)},
+		{"nested.ids.0", int64(9007199254740993)},
+		{"string", "1"},
+	}
+	for _, tc := range passing {
+		if failed(func(t *testing.T) {
+			rec.t = t
+			res.ExpectJSONField(tc.path, tc.expected)
+		}) {
+			t.Errorf("Expected ExpectJSONField(%s, %v) to pass", tc.path, tc.expected)
+		}
+	}
+
+	failing := []struct {
+		path     string
+		expected interface{}
+	}{
+		{"int", 2},
+		{"float", 1.5},
+		{"tenth", 0.2},
+		// These are equal when converted to float64, but not as integers.
+		{"big", int64(9007199254740992)},
+		{"big", float64(9007199254740992)},
+		{"nested.ids.0", int64(9007199254740994)},
+		{"maxUint", uint64(math.MaxUint64 - 1)},
+		{"string", 1},
+		{"int", "1"},
+		{"missing", 1},
+		{"int", math.NaN()},
+	}
+	for _, tc := range failing {
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			res.ExpectJSONField(tc.path, tc.expected)
+		}) {
+			t.Errorf("Expected ExpectJSONField(%s, %v) to fail", tc.path, tc.expected)
+		}
+	}
+}
+
+func TestExpectBodyEqualsJSON(t *testing.T) {
+	body := `{"name": "alice", "roles": ["admin", "user"], "age": 30}`
+	for _, contentType := range []string{"application/json", "text/plain", ""} {
+		rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Prevent the http package from sniffing a Content-Type.
+			w.Header()["Content-Type"] = []string{contentType}
+			w.Write([]byte(body))
+		}))
+		res := rec.Get("/")
+		res.ExpectBodyEqualsJSON(`{"age":30,"roles":["admin","user"],"name":"alice"}`)
+		res.ExpectBodyEqualsJSON("{\n  \"name\": \"alice\",\n  \"roles\": [\"admin\", \"user\"],\n  \"age\": 30.0\n}")
+
+		for _, expected := range []string{
+			`{"name":"alice","roles":["user","admin"],"age":30}`,
+			`{"name":"alice","roles":["admin","user"]}`,
+			`{"name":"alice","roles":["admin","user"],"age":"30"}`,
+			`{"name":"bob","roles":["admin","user"],"age":30}`,
+			`[]`,
+			`not json`,
+		} {
+			if !failed(func(t *testing.T) {
+				rec.t = t
+				res.ExpectBodyEqualsJSON(expected)
+			}) {
+				t.Errorf("Expected ExpectBodyEqualsJSON(%s) to fail with Content-Type %q", expected, contentType)
+			}
+		}
+		rec.Close()
+	}
+
+	rec := NewRecorder(t, bodyHandler("not json"))
+	defer rec.Close()
+	res := rec.Get("/")
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		res.ExpectBodyEqualsJSON(`{}`)
+	}) {
+		t.Error("Expected ExpectBodyEqualsJSON to fail for a body which is not JSON")
+	}
+}
+
+func TestStrictJSONDecoding(t *testing.T) {
+	body := `[{"b":1,"a":2}]`
+	decoders := map[string]func(res *Response){
+		"CanonicalJSON": func(res *Response) {
+			res.CanonicalJSON()
+		},
+		"DecodeJSONArray": func(res *Response) {
+			res.DecodeJSONArray(func(elem json.RawMessage) error { return nil })
+		},
+	}
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	}))
+	defer rec.Close()
+	res := rec.Get("/")
+	for name, decode := range decoders {
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			decode(res)
+		}) {
+			t.Errorf("Expected %s to fail when StrictJSON is true and the Content-Type is not JSON", name)
+		}
+	}
+
+	rec.t = t
+	rec.StrictJSON = false
+	if canonical := string(res.CanonicalJSON()); canonical != `[{"a":2,"b":1}]` {
+		t.Errorf("Expected CanonicalJSON to return %s when StrictJSON is false but got %s", `[{"a":2,"b":1}]`, canonical)
+	}
+	count := 0
+	res.DecodeJSONArray(func(elem json.RawMessage) error {
+		count++
+		return nil
+	})
+	if count != 1 {
+		t.Errorf("Expected DecodeJSONArray to decode 1 element when StrictJSON is false but got %d", count)
+	}
+}
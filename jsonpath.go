@@ -0,0 +1,590 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep transforms the list of values matched so far into the list of
+// values matched by the next segment of a JSONPath expression.
+type jsonPathStep func(nodes []interface{}) []interface{}
+
+// jsonPathSelector returns the values selected from the children of a single
+// value.
+type jsonPathSelector func(v interface{}) []interface{}
+
+// JSONPath evaluates the JSONPath expression expr against the body of the
+// response and returns all of the matching values, in document order. The
+// following syntax is supported:
+//
+//	$                 the top-level value
+//	.name or ['name'] a child of an object
+//	[n]               an element of an array (negative counts from the end)
+//	[start:end]       a slice of an array
+//	.* or [*]         all children of an object or array
+//	..name or ..*     recursive descent
+//	['a','b'] [0,1]   a union of names or indexes
+//	[?(filter)]       children which match filter
+//
+// A filter consists of comparisons between values relative to the child being
+// tested (written with @, e.g. @.price) and literals (numbers, quoted strings,
+// true, false, and null) using ==, !=, <, <=, >, and >=. A value relative to @
+// by itself tests whether it exists. Comparisons can be combined with && and
+// ||, where && binds more tightly. Names containing an operator must be
+// quoted inside a filter, e.g. @['a=b']. For example:
+// $.items[?(@.active==true && @.price<10)].id
+//
+// An error is returned if expr is not valid. Any errors that occur while
+// decoding the body will be passed to t.Fatal.
+func (r *Response) JSONPath(expr string) ([]interface{}, error) {
+	steps, err := parseJSONPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return evalJSONPath(steps, r.decodeJSON()), nil
+}
+
+// ExpectJSONPath causes a test error if the values matched by the JSONPath
+// expression expr (see JSONPath) are not equal to expected, when both are
+// encoded as JSON. If expected is a slice or array, it is compared to the list
+// of all matching values. Otherwise, expr must match exactly one value, which
+// is compared to expected.
+func (r *Response) ExpectJSONPath(expr string, expected interface{}) {
+	r.subtest(fmt.Sprintf("ExpectJSONPath(%s)", expr), func() {
+		results, err := r.JSONPath(expr)
+		if err != nil {
+			r.recorder.t.Fatal(err)
+		}
+		var actual interface{} = results
+		kind := reflect.ValueOf(expected).Kind()
+		if kind != reflect.Slice && kind != reflect.Array {
+			if len(results) != 1 {
+				r.PrintFailureOnce()
+				r.errorf("Expected JSONPath `%s` to match exactly one value but it matched %d.", expr, len(results))
+				return
+			}
+			actual = results[0]
+		}
+		if normalized := r.normalizeJSON(expected); !reflect.DeepEqual(normalized, actual) {
+			r.PrintFailureOnce()
+			r.errorf("Expected JSONPath `%s` to be %s but got: %s", expr, jsonString(normalized), jsonString(actual))
+		}
+	})
+}
+
+// evalJSONPath applies steps to root and returns the resulting values.
+func evalJSONPath(steps []jsonPathStep, root interface{}) []interface{} {
+	nodes := []interface{}{root}
+	for _, step := range steps {
+		nodes = step(nodes)
+	}
+	return nodes
+}
+
+// parseJSONPath parses a JSONPath expression into a list of steps.
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("fipple: invalid JSONPath %q: must start with $", expr)
+	}
+	steps := []jsonPathStep{}
+	for i := 1; i < len(expr); {
+		recursive := false
+		switch {
+		case strings.HasPrefix(expr[i:], ".."):
+			recursive = true
+			i += 2
+		case expr[i] == '.':
+			i++
+		case expr[i] == '[':
+		default:
+			return nil, fmt.Errorf("fipple: invalid JSONPath %q: unexpected %q at offset %d", expr, expr[i], i)
+		}
+		var selector jsonPathSelector
+		if i < len(expr) && expr[i] == '[' {
+			end, err := jsonPathBracketEnd(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			selector, err = parseJSONPathBracket(expr[i+1 : end])
+			if err != nil {
+				return nil, fmt.Errorf("fipple: invalid JSONPath %q: %s", expr, err)
+			}
+			i = end + 1
+		} else {
+			end := i
+			for end < len(expr) && expr[end] != '.' && expr[end] != '[' {
+				end++
+			}
+			name := expr[i:end]
+			if name == "" {
+				return nil, fmt.Errorf("fipple: invalid JSONPath %q: missing name at offset %d", expr, i)
+			}
+			if name == "*" {
+				selector = selectJSONWildcard
+			} else {
+				selector = selectJSONNames([]string{name})
+			}
+			i = end
+		}
+		steps = append(steps, newJSONPathStep(selector, recursive))
+	}
+	return steps, nil
+}
+
+// newJSONPathStep returns a step which applies selector to each node, or to
+// each node and all of its descendants if recursive is true.
+func newJSONPathStep(selector jsonPathSelector, recursive bool) jsonPathStep {
+	return func(nodes []interface{}) []interface{} {
+		results := []interface{}{}
+		for _, node := range nodes {
+			if recursive {
+				for _, descendant := range jsonDescendants(node) {
+					results = append(results, selector(descendant)...)
+				}
+			} else {
+				results = append(results, selector(node)...)
+			}
+		}
+		return results
+	}
+}
+
+// jsonPathBracketEnd returns the index of the ']' which closes the '[' at
+// index start of expr, skipping over any quoted strings and parentheses.
+func jsonPathBracketEnd(expr string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start + 1; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ']' && depth == 0:
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("fipple: invalid JSONPath %q: unclosed [ at offset %d", expr, start)
+}
+
+// parseJSONPathBracket parses the contents of a bracket segment, e.g. 'name',
+// 0, 1:3, *, or ?(@.active).
+func parseJSONPathBracket(content string) (jsonPathSelector, error) {
+	content = strings.TrimSpace(content)
+	switch {
+	case content == "*":
+		return selectJSONWildcard, nil
+	case strings.HasPrefix(content, "?"):
+		filter := strings.TrimSpace(content[1:])
+		if !strings.HasPrefix(filter, "(") || !strings.HasSuffix(filter, ")") {
+			return nil, fmt.Errorf("filter must be enclosed in parentheses: %q", content)
+		}
+		predicate, err := parseJSONPathFilter(filter[1 : len(filter)-1])
+		if err != nil {
+			return nil, err
+		}
+		return selectJSONFilter(predicate), nil
+	case strings.Contains(content, ":"):
+		return parseJSONPathSlice(content)
+	}
+	names := []string{}
+	indexes := []int{}
+	for _, item := range splitJSONPathOutsideQuotes(content, ",") {
+		item = strings.TrimSpace(item)
+		if name, ok := unquoteJSONPathString(item); ok {
+			names = append(names, name)
+			continue
+		}
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q", item)
+		}
+		indexes = append(indexes, i)
+	}
+	if len(names) > 0 && len(indexes) > 0 {
+		return nil, fmt.Errorf("cannot mix names and indexes: %q", content)
+	}
+	if len(names) > 0 {
+		return selectJSONNames(names), nil
+	}
+	return selectJSONIndexes(indexes), nil
+}
+
+// parseJSONPathSlice parses an array slice of the form start:end or
+// start:end:step. Any of the parts may be omitted.
+func parseJSONPathSlice(content string) (jsonPathSelector, error) {
+	parts := strings.Split(content, ":")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid slice %q", content)
+	}
+	values := []*int{}
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			values = append(values, nil)
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid slice %q", content)
+		}
+		values = append(values, &n)
+	}
+	step := 1
+	if len(values) == 3 && values[2] != nil {
+		step = *values[2]
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("slice step must be positive: %q", content)
+	}
+	return func(v interface{}) []interface{} {
+		array, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		start, end := 0, len(array)
+		if values[0] != nil {
+			start = clampJSONIndex(*values[0], len(array))
+		}
+		if values[1] != nil {
+			end = clampJSONIndex(*values[1], len(array))
+		}
+		results := []interface{}{}
+		for i := start; i < end; i += step {
+			results = append(results, array[i])
+		}
+		return results
+	}, nil
+}
+
+// clampJSONIndex converts a possibly negative slice index into an index in
+// the range [0, length].
+func clampJSONIndex(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > length {
+		return length
+	}
+	return i
+}
+
+// selectJSONNames returns a selector which selects the values of the given
+// keys of an object.
+func selectJSONNames(names []string) jsonPathSelector {
+	return func(v interface{}) []interface{} {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		results := []interface{}{}
+		for _, name := range names {
+			if value, found := obj[name]; found {
+				results = append(results, value)
+			}
+		}
+		return results
+	}
+}
+
+// selectJSONIndexes returns a selector which selects the elements of an array
+// at the given indexes. Negative indexes count from the end of the array.
+func selectJSONIndexes(indexes []int) jsonPathSelector {
+	return func(v interface{}) []interface{} {
+		array, ok := v.([]interface{})
+		if !ok {
+			return nil
+		}
+		results := []interface{}{}
+		for _, i := range indexes {
+			if i < 0 {
+				i += len(array)
+			}
+			if i >= 0 && i < len(array) {
+				results = append(results, array[i])
+			}
+		}
+		return results
+	}
+}
+
+// selectJSONWildcard selects all the children of an object or array. The
+// children of an object are selected in order of their keys.
+func selectJSONWildcard(v interface{}) []interface{} {
+	switch typed := v.(type) {
+	case []interface{}:
+		return typed
+	case map[string]interface{}:
+		results := []interface{}{}
+		for _, key := range sortedJSONKeys(typed) {
+			results = append(results, typed[key])
+		}
+		return results
+	}
+	return nil
+}
+
+// selectJSONFilter returns a selector which selects the children of an object
+// or array which match predicate.
+func selectJSONFilter(predicate func(v interface{}) bool) jsonPathSelector {
+	return func(v interface{}) []interface{} {
+		results := []interface{}{}
+		for _, child := range selectJSONWildcard(v) {
+			if predicate(child) {
+				results = append(results, child)
+			}
+		}
+		return results
+	}
+}
+
+// jsonDescendants returns v followed by all of its descendants, in document
+// order.
+func jsonDescendants(v interface{}) []interface{} {
+	results := []interface{}{v}
+	for _, child := range selectJSONWildcard(v) {
+		results = append(results, jsonDescendants(child)...)
+	}
+	return results
+}
+
+// sortedJSONKeys returns the keys of obj in sorted order.
+func sortedJSONKeys(obj map[string]interface{}) []string {
+	keys := []string{}
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// jsonPathOperators are the comparison operators supported in filters. Longer
+// operators come first so that e.g. <= is not mistaken for <.
+var jsonPathOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseJSONPathFilter parses a filter expression into a predicate which tests
+// a single value (referred to as @ in the expression).
+func parseJSONPathFilter(filter string) (func(v interface{}) bool, error) {
+	if alternatives := splitJSONPathOutsideQuotes(filter, "||"); len(alternatives) > 1 {
+		predicates, err := parseJSONPathFilters(alternatives)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool {
+			for _, predicate := range predicates {
+				if predicate(v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	if conjuncts := splitJSONPathOutsideQuotes(filter, "&&"); len(conjuncts) > 1 {
+		predicates, err := parseJSONPathFilters(conjuncts)
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool {
+			for _, predicate := range predicates {
+				if !predicate(v) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+	for _, op := range jsonPathOperators {
+		parts := splitJSONPathOutsideQuotes(filter, op)
+		if len(parts) == 1 {
+			continue
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid filter %q", filter)
+		}
+		left, err := parseJSONPathOperand(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseJSONPathOperand(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) bool {
+			l, lok := left(v)
+			r, rok := right(v)
+			return lok && rok && compareJSONValues(l, op, r)
+		}, nil
+	}
+	operand, err := parseJSONPathOperand(filter)
+	if err != nil {
+		return nil, err
+	}
+	return func(v interface{}) bool {
+		_, found := operand(v)
+		return found
+	}, nil
+}
+
+// parseJSONPathFilters parses each of the given filter expressions.
+func parseJSONPathFilters(filters []string) ([]func(v interface{}) bool, error) {
+	predicates := []func(v interface{}) bool{}
+	for _, filter := range filters {
+		predicate, err := parseJSONPathFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+	}
+	return predicates, nil
+}
+
+// parseJSONPathOperand parses one side of a comparison in a filter. It is
+// either a path relative to the value being tested (starting with @) or a
+// literal. The returned function returns the value of the operand, or false if
+// the path does not match any value.
+func parseJSONPathOperand(operand string) (func(v interface{}) (interface{}, bool), error) {
+	operand = strings.TrimSpace(operand)
+	if strings.HasPrefix(operand, "@") {
+		// An operator character outside of quotes means the filter was
+		// malformed (e.g. @.a=<1), rather than part of a name.
+		for _, c := range []string{"=", "!", "<", ">", "&", "|"} {
+			if len(splitJSONPathOutsideQuotes(operand, c)) > 1 {
+				return nil, fmt.Errorf("invalid operand %q in filter", operand)
+			}
+		}
+		steps, err := parseJSONPath("$" + operand[1:])
+		if err != nil {
+			return nil, err
+		}
+		return func(v interface{}) (interface{}, bool) {
+			results := evalJSONPath(steps, v)
+			if len(results) == 0 {
+				return nil, false
+			}
+			return results[0], true
+		}, nil
+	}
+	var literal interface{}
+	if str, ok := unquoteJSONPathString(operand); ok {
+		literal = str
+	} else if err := json.Unmarshal([]byte(operand), &literal); err != nil {
+		return nil, fmt.Errorf("invalid operand %q in filter", operand)
+	}
+	return func(interface{}) (interface{}, bool) {
+		return literal, true
+	}, nil
+}
+
+// compareJSONValues compares two generic JSON values using the given
+// operator. Ordering comparisons are only supported between two numbers or two
+// strings.
+func compareJSONValues(left interface{}, op string, right interface{}) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(left, right)
+	case "!=":
+		return !reflect.DeepEqual(left, right)
+	}
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false
+	}
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// unquoteJSONPathString returns the contents of a single- or double-quoted
+// string. The second return value is false if s is not quoted.
+func unquoteJSONPathString(s string) (string, bool) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", false
+	}
+	inner := s[1 : len(s)-1]
+	unescaped := &strings.Builder{}
+	for i := 0; i < len(inner); i++ {
+		if inner[i] == '\\' && i+1 < len(inner) {
+			i++
+		}
+		unescaped.WriteByte(inner[i])
+	}
+	return unescaped.String(), true
+}
+
+// splitJSONPathOutsideQuotes splits s around each instance of sep which is
+// not inside a quoted string or parentheses.
+func splitJSONPathOutsideQuotes(s string, sep string) []string {
+	parts := []string{}
+	depth := 0
+	var quote byte
+	last := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[last:i])
+			i += len(sep) - 1
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
@@ -0,0 +1,247 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+const jsonPathDocument = `{
+	"store": {
+		"name": "corner shop",
+		"items": [
+			{"id": 1, "name": "apple", "price": 0.5, "active": true},
+			{"id": 2, "name": "bread", "price": 3, "active": false},
+			{"id": 3, "name": "cheese", "price": 12, "active": true, "tags": ["dairy"]},
+			{"id": 4, "name": "wine", "price": 15, "active": true}
+		],
+		"owner's note": "closed on sundays"
+	}
+}`
+
+func TestJSONPathGrammar(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonPathDocument), &doc); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		expr     string
+		expected string
+	}{
+		{`$`, `[` + jsonPathDocument + `]`},
+		{`$.store.name`, `["corner shop"]`},
+		{`$.store.missing`, `[]`},
+		{`$['store']['name']`, `["corner shop"]`},
+		{`$["store"].name`, `["corner shop"]`},
+		{`$.store['owner\'s note']`, `["closed on sundays"]`},
+		{`$.store.items[0].name`, `["apple"]`},
+		{`$.store.items[-1].name`, `["wine"]`},
+		{`$.store.items[10].name`, `[]`},
+		{`$.store.items[1:3].id`, `[2,3]`},
+		{`$.store.items[:2].id`, `[1,2]`},
+		{`$.store.items[-2:].id`, `[3,4]`},
+		{`$.store.items[::2].id`, `[1,3]`},
+		{`$.store.items[*].id`, `[1,2,3,4]`},
+		{`$.store.items.*.id`, `[1,2,3,4]`},
+		{`$.store.items[0].*`, `[true,1,"apple",0.5]`},
+		{`$.store.items[0,-1].id`, `[1,4]`},
+		{`$.store.items[0]['id','name']`, `[1,"apple"]`},
+		{`$..id`, `[1,2,3,4]`},
+		{`$..tags[0]`, `["dairy"]`},
+		{`$.store..*.tags.*`, `["dairy"]`},
+		{`$.store.items[?(@.tags)].id`, `[3]`},
+		{`$.store.items[?(@.active==true)].id`, `[1,3,4]`},
+		{`$.store.items[?(@.active != true)].id`, `[2]`},
+		{`$.store.items[?(@.price<3)].id`, `[1]`},
+		{`$.store.items[?(@.price<=3)].id`, `[1,2]`},
+		{`$.store.items[?(@.price>12)].id`, `[4]`},
+		{`$.store.items[?(@.price>=12)].id`, `[3,4]`},
+		{`$.store.items[?(@.name=='bread')].id`, `[2]`},
+		{`$.store.items[?(@.name>"bread")].id`, `[3,4]`},
+		{`$.store.items[?(@.name<3)].id`, `[]`},
+		{`$.store.items[?(@.active==true && @.price<13)].id`, `[1,3]`},
+		{`$.store.items[?(@.price<1 || @.price>14)].id`, `[1,4]`},
+		{`$.store.items[?(@.active==true && @.price>1 || @.id==2)].id`, `[2,3,4]`},
+		{`$.store.items[?(@.name=='a||b' || @.name=='a&&b')].id`, `[]`},
+		{`$.store.items[?(@.tags[0]=='dairy')].name`, `["cheese"]`},
+	}
+	for _, tc := range testCases {
+		steps, err := parseJSONPath(tc.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", tc.expr, err)
+			continue
+		}
+		var expected interface{}
+		if err := json.Unmarshal([]byte(tc.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if actual := evalJSONPath(steps, doc); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %s to match %s but got: %s", tc.expr, tc.expected, jsonString(actual))
+		}
+	}
+}
+
+// jsonPathEdgeCaseDocument has keys and values containing characters which
+// are part of the JSONPath syntax.
+const jsonPathEdgeCaseDocument = `{
+	"a.b": 1,
+	"a]b": 2,
+	"a": {"b": 3},
+	"x\"y": 4,
+	"nums": [0, 1, 2, 3, 4],
+	"items": [
+		{"id": 1, "name": "a==b", "op": "<"},
+		{"id": 2, "name": "a", "op": "<=", "k=v": true},
+		{"id": 3, "name": "a&&b", "op": ">"}
+	]
+}`
+
+func TestJSONPathEdgeCases(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(jsonPathEdgeCaseDocument), &doc); err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		expr     string
+		expected string
+	}{
+		// Quoted keys containing characters which are part of the syntax.
+		{`$['a.b']`, `[1]`},
+		{`$["a.b"]`, `[1]`},
+		{`$.a.b`, `[3]`},
+		{`$['a]b']`, `[2]`},
+		{`$["x\"y"]`, `[4]`},
+		{`$['a.b','a]b']`, `[1,2]`},
+		// Negative slice bounds count from the end and are clamped.
+		{`$.nums[-3:-1]`, `[2,3]`},
+		{`$.nums[1:-1]`, `[1,2,3]`},
+		{`$.nums[-1:-3]`, `[]`},
+		{`$.nums[-10:2]`, `[0,1]`},
+		{`$.nums[-2:10]`, `[3,4]`},
+		// Operators inside quoted literals are part of the literal.
+		{`$.items[?(@.name=='a==b')].id`, `[1]`},
+		{`$.items[?(@.name=="a&&b")].id`, `[3]`},
+		{`$.items[?(@.op=='<=')].id`, `[2]`},
+		{`$.items[?(@.op<'<=')].id`, `[1]`},
+		{`$.items[?(@.op>='<=')].id`, `[2,3]`},
+		{`$.items[?(@['k=v'])].id`, `[2]`},
+		{`$.items[?(@['k=v']==true && @.op=='<=')].id`, `[2]`},
+		// && binds more tightly than ||.
+		{`$.items[?(@.id==2 || @.id==1 && @.id==3)].id`, `[2]`},
+		{`$.items[?(@.id==3 || @.id==1 && @.name=='a==b')].id`, `[1,3]`},
+		{`$.items[?(@.id==1 && @.id==3 || @.id==2)].id`, `[2]`},
+		{`$.items[?(@.id>1 && @.id<3 || @.id==1 && @.op=='<')].id`, `[1,2]`},
+	}
+	for _, tc := range testCases {
+		steps, err := parseJSONPath(tc.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %s", tc.expr, err)
+			continue
+		}
+		var expected interface{}
+		if err := json.Unmarshal([]byte(tc.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if actual := evalJSONPath(steps, doc); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Expected %s to match %s but got: %s", tc.expr, tc.expected, jsonString(actual))
+		}
+	}
+}
+
+func TestJSONPathMalformed(t *testing.T) {
+	testCases := []string{
+		``,
+		`   `,
+		`store.name`,
+		`$.`,
+		`$..`,
+		`$.store.`,
+		`$store`,
+		`$[`,
+		`$.items[0`,
+		`$['name`,
+		`$['name]`,
+		`$[]`,
+		`$[abc]`,
+		`$['a',0]`,
+		`$[1:2:3:4]`,
+		`$[a:b]`,
+		`$[::0]`,
+		`$[?(@.a==1]`,
+		`$[?@.a==1]`,
+		`$[?()]`,
+		`$[?(@.a==)]`,
+		`$[?(==1)]`,
+		`$[?(@.a==1 && )]`,
+		`$[?(|| @.a)]`,
+		`$[?(@.a==bogus)]`,
+		`$[?(@.a==1==2)]`,
+		`$[?(@[)]`,
+		`$[?(@.)]`,
+		`$['a.b'`,
+		`$['a]b`,
+		`$["x\"]`,
+		`$[-3:-1`,
+		`$[-a:-1]`,
+		`$[?(@.name=='a==b)]`,
+		`$[?(@.op<'<=)]`,
+		`$[?(@.a==1 && || @.b==2)]`,
+		`$[?(@.a==1 |)]`,
+		`$[?(@.a==1 & @.b==2)]`,
+		`$[?(@.a=<1)]`,
+		`$[?(@.a! == 1)]`,
+		`$[?(@.k=v)]`,
+		`$[?(@.a===1)]`,
+	}
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(jsonPathDocument))
+	}))
+	defer rec.Close()
+	res := rec.Get("/")
+	for _, expr := range testCases {
+		if _, err := parseJSONPath(expr); err == nil {
+			t.Errorf("Expected an error parsing %q but got none", expr)
+		}
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			res.ExpectJSONPath(expr, "anything")
+		}) {
+			t.Errorf("Expected ExpectJSONPath(%q) to fail the test", expr)
+		}
+	}
+}
+
+func TestExpectJSONPath(t *testing.T) {
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(jsonPathDocument))
+	}))
+	defer rec.Close()
+	res := rec.Get("/")
+	res.ExpectJSONPath("$.store.items[?(@.active==true)].id", []int{1, 3, 4})
+	res.ExpectJSONPath("$.store.items[1].name", "bread")
+
+	testCases := []struct {
+		expr     string
+		expected interface{}
+	}{
+		{"$.store.items[*].id", 1},
+		{"$.store.missing", "anything"},
+		{"$.store.items[1].name", "apple"},
+		{"$.store.items[*].id", []int{1, 2}},
+	}
+	for _, tc := range testCases {
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			res.ExpectJSONPath(tc.expr, tc.expected)
+		}) {
+			t.Errorf("Expected ExpectJSONPath(%s, %v) to fail", tc.expr, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"math"
+)
+
+// ResponseMap provides typed access to the values in a JSON response body.
+// It is returned by Response.Map. Each getter takes a dot-separated path
+// (e.g. "users.0.email") and passes any errors, including a missing value or
+// a value of the wrong type, to t.Fatal.
+type ResponseMap struct {
+	response *Response
+	value    interface{}
+}
+
+// Map decodes the JSON body of the response and returns a ResponseMap which
+// can be used to extract values from it. Any errors that occur while decoding
+// the body will be passed to t.Fatal.
+func (r *Response) Map() *ResponseMap {
+	return &ResponseMap{
+		response: r,
+		value:    r.decodeJSON(),
+	}
+}
+
+// get returns the value at the given path. If there is no value at path, the
+// failure is passed to t.Fatal.
+func (m *ResponseMap) get(path string) interface{} {
+	v, found := lookupJSONPath(m.value, path)
+	if !found {
+		m.response.PrintFailureOnce()
+		m.response.recorder.t.Fatalf("Expected JSON field %s to exist but it did not.", jsonPathName(path))
+	}
+	return v
+}
+
+// fatalType passes a failure to t.Fatal which explains that the value v at the
+// given path is not of the expected type.
+func (m *ResponseMap) fatalType(path string, expected string, v interface{}) {
+	m.response.PrintFailureOnce()
+	m.response.recorder.t.Fatalf("Expected JSON field %s to be %s but got %s: %s", jsonPathName(path), expected, jsonTypeName(v), jsonString(v))
+}
+
+// GetString returns the string at the given path.
+func (m *ResponseMap) GetString(path string) string {
+	v := m.get(path)
+	s, ok := v.(string)
+	if !ok {
+		m.fatalType(path, "a string", v)
+	}
+	return s
+}
+
+// GetInt returns the number at the given path, which must be an integer that
+// fits in an int.
+func (m *ResponseMap) GetInt(path string) int {
+	v := m.get(path)
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 || float64(int(f)) != f {
+		m.fatalType(path, "an integer", v)
+	}
+	return int(f)
+}
+
+// GetBool returns the boolean at the given path.
+func (m *ResponseMap) GetBool(path string) bool {
+	v := m.get(path)
+	b, ok := v.(bool)
+	if !ok {
+		m.fatalType(path, "a boolean", v)
+	}
+	return b
+}
+
+// GetArray returns the array at the given path. The elements of the array
+// have the same types used by json.Unmarshal when decoding into an
+// interface{}.
+func (m *ResponseMap) GetArray(path string) []interface{} {
+	v := m.get(path)
+	array, ok := v.([]interface{})
+	if !ok {
+		m.fatalType(path, "an array", v)
+	}
+	return array
+}
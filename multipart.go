@@ -0,0 +1,68 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// Part is a single part of a multipart response, such as the response to one
+// of the requests in a batch.
+type Part struct {
+	// Header holds the headers of the part.
+	Header textproto.MIMEHeader
+	// Body is the body of the part.
+	Body []byte
+}
+
+// Parts parses the body of the response, which must have a multipart
+// Content-Type (e.g. multipart/mixed or multipart/related), and returns its
+// parts in order. Any errors that occur, including a Content-Type which is not
+// multipart, will be passed to t.Fatal.
+func (r *Response) Parts() []*Part {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		r.PrintFailureOnce()
+		r.recorder.t.Fatalf("Expected multipart response with a boundary but Content-Type was %q", r.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(bytes.NewReader(r.RawBody), params["boundary"])
+	parts := []*Part{}
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			r.recorder.t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			r.recorder.t.Fatal(err)
+		}
+		parts = append(parts, &Part{
+			Header: part.Header,
+			Body:   body,
+		})
+	}
+}
+
+// ExpectPartCount causes a test error if the multipart body of the response
+// does not have exactly n parts. Any errors that occur while parsing the body
+// will be passed to t.Fatal.
+func (r *Response) ExpectPartCount(n int) {
+	r.subtest(fmt.Sprintf("ExpectPartCount(%d)", n), func() {
+		if count := len(r.Parts()); count != n {
+			r.PrintFailureOnce()
+			r.errorf("Expected multipart response to have %d parts but got: %d", n, count)
+		}
+	})
+}
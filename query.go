@@ -0,0 +1,123 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NewRequestWithQueryStruct creates a new request object with the given http
+// method and path, and with the fields of params encoded into the query
+// string. params must be a struct or a pointer to a struct. The name of each
+// parameter is taken from the url tag of the field (e.g. `url:"page"`), or
+// from the name of the field if it has no url tag. Fields tagged `url:"-"` and
+// unexported fields are skipped, and fields tagged with the omitempty option
+// (e.g. `url:"q,omitempty"`) are skipped if they have the zero value. Strings,
+// numbers, and bools are supported, as well as slices and arrays of them,
+// which are encoded as repeated parameters. Nil pointers are skipped. The path
+// will be appended to the baseURL for the recorder to create the full URL. Any
+// errors that occur will be passed to t.Fatal.
+func (r *Recorder) NewRequestWithQueryStruct(method string, path string, params interface{}) *http.Request {
+	query, err := encodeQueryStruct(params)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req := r.NewRequest(method, path)
+	values := req.URL.Query()
+	for key, vs := range query {
+		values[key] = append(values[key], vs...)
+	}
+	req.URL.RawQuery = values.Encode()
+	return req
+}
+
+// encodeQueryStruct encodes the fields of params, which must be a struct or a
+// pointer to a struct, into query parameters. See NewRequestWithQueryStruct.
+func encodeQueryStruct(params interface{}) (url.Values, error) {
+	v := reflect.ValueOf(params)
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return url.Values{}, nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("fipple: expected query params to be a struct but got %T", params)
+	}
+	query := url.Values{}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, opts := field.Name, ""
+		if tag, found := field.Tag.Lookup("url"); found {
+			if tag == "-" {
+				continue
+			}
+			name, opts = tag, ""
+			if comma := strings.Index(tag, ","); comma != -1 {
+				name, opts = tag[:comma], tag[comma+1:]
+			}
+			if name == "" {
+				name = field.Name
+			}
+		}
+		value := v.Field(i)
+		if opts == "omitempty" && value.IsZero() {
+			continue
+		}
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				break
+			}
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Ptr {
+			continue
+		}
+		if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+			for j := 0; j < value.Len(); j++ {
+				s, err := queryValueString(value.Index(j))
+				if err != nil {
+					return nil, fmt.Errorf("fipple: query param %s: %s", name, err)
+				}
+				query.Add(name, s)
+			}
+			continue
+		}
+		s, err := queryValueString(value)
+		if err != nil {
+			return nil, fmt.Errorf("fipple: query param %s: %s", name, err)
+		}
+		query.Add(name, s)
+	}
+	return query, nil
+}
+
+// queryValueString converts a string, number, or bool into its
+// representation in a query string.
+func queryValueString(v reflect.Value) (string, error) {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 32), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unsupported type %s", v.Type())
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEncodeQueryStruct(t *testing.T) {
+	page := 2
+	var nilPage *int
+	testCases := []struct {
+		params   interface{}
+		expected string
+	}{
+		{struct{ Name string }{"alice"}, "Name=alice"},
+		{struct {
+			Name string `url:"name"`
+		}{"alice bob"}, "name=alice+bob"},
+		{struct {
+			Page   int     `url:"page"`
+			Limit  uint8   `url:"limit"`
+			Active bool    `url:"active"`
+			Score  float64 `url:"score"`
+			Ratio  float32 `url:"ratio"`
+		}{3, 10, true, 1.5, 0.25}, "active=true&limit=10&page=3&ratio=0.25&score=1.5"},
+		{struct {
+			Q      string `url:"q,omitempty"`
+			Page   int    `url:"page,omitempty"`
+			Sort   string `url:"sort"`
+			Hidden string `url:"-"`
+			secret string
+		}{Sort: "", Hidden: "x", secret: "y"}, "sort="},
+		{struct {
+			Q string `url:",omitempty"`
+		}{"x"}, "Q=x"},
+		{struct {
+			Tags []string `url:"tag"`
+			IDs  [2]int   `url:"id"`
+			None []string `url:"none,omitempty"`
+		}{Tags: []string{"a", "b"}, IDs: [2]int{1, 2}}, "id=1&id=2&tag=a&tag=b"},
+		{struct {
+			Page  *int `url:"page"`
+			Limit *int `url:"limit"`
+		}{Page: &page, Limit: nilPage}, "page=2"},
+		{&struct {
+			Name string `url:"name"`
+		}{"ptr"}, "name=ptr"},
+		{(*struct{ Name string })(nil), ""},
+	}
+	for _, tc := range testCases {
+		query, err := encodeQueryStruct(tc.params)
+		if err != nil {
+			t.Errorf("Unexpected error encoding %#v: %s", tc.params, err)
+			continue
+		}
+		if actual := query.Encode(); actual != tc.expected {
+			t.Errorf("Expected %#v to be encoded as %q but got %q", tc.params, tc.expected, actual)
+		}
+	}
+}
+
+func TestEncodeQueryStructUnsupported(t *testing.T) {
+	testCases := []interface{}{
+		nil,
+		"not a struct",
+		map[string]string{"a": "b"},
+		[]string{"a"},
+		struct {
+			Filter struct{ Name string } `url:"filter"`
+		}{},
+		struct {
+			Filter map[string]string `url:"filter"`
+		}{Filter: map[string]string{"a": "b"}},
+		struct {
+			Since time.Time `url:"since"`
+		}{},
+		struct {
+			Matrix [][]int `url:"matrix"`
+		}{Matrix: [][]int{{1}}},
+		struct {
+			Any interface{} `url:"any"`
+		}{Any: "x"},
+		struct {
+			Fn func() `url:"fn"`
+		}{Fn: func() {}},
+	}
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	for _, params := range testCases {
+		if _, err := encodeQueryStruct(params); err == nil {
+			t.Errorf("Expected an error encoding %#v but got none", params)
+		}
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			rec.NewRequestWithQueryStruct("GET", "/", params)
+		}) {
+			t.Errorf("Expected NewRequestWithQueryStruct(%#v) to fail the test", params)
+		}
+	}
+}
+
+func TestNewRequestWithQueryStruct(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	params := struct {
+		Page int      `url:"page"`
+		Tags []string `url:"tag"`
+	}{2, []string{"b"}}
+	req := rec.NewRequestWithQueryStruct("GET", "/items?tag=a", params)
+	rec.Do(req).ExpectBodyEquals("/items?page=2&tag=a&tag=b\n")
+}
@@ -8,51 +8,225 @@ package fipple
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 // Recorder can be used to send http requests and record the responses.
 type Recorder struct {
-	t       *testing.T
-	client  *http.Client
-	baseURL string
-	server  *httptest.Server
+	t         *testing.T
+	client    *http.Client
+	transport *http.Transport
+	baseURL   string
+	server    *httptest.Server
+	services  map[string]string
+	// redirectPolicy, if set, decides whether to follow each redirect after
+	// PreserveHeadersOnRedirect and StripHeadersOnRedirect have been applied.
+	// See SetMaxRedirects and SetCheckRedirect.
+	redirectPolicy func(req *http.Request, via []*http.Request) error
+	handlerLog     *handlerLog
 	// Colorize is used to determine whether or not to colorize the errors when
 	// printing to the console using t.Error. The default is true.
 	Colorize bool
+	// LoginCode is the response code that Login expects to receive when the
+	// credentials are accepted. The default is 200.
+	LoginCode int
+	// ReplayMode determines whether responses are recorded to and replayed
+	// from fixture files in FixturesDir. The default is ReplayOff, which means
+	// every request is sent over the network.
+	ReplayMode ReplayMode
+	// FixturesDir is the directory where fixture files are stored when
+	// ReplayMode is not ReplayOff.
+	FixturesDir string
+	// ResponseArchiveDir, if set, is a directory where the raw body of every
+	// response recorded by the recorder is saved, along with a small metadata
+	// file containing the method, URL, and status code. Each response is saved
+	// to a uniquely-named file. This is useful for inspecting the responses
+	// after a CI run. Errors which occur while archiving are logged via t.Log
+	// and do not cause the test to fail.
+	ResponseArchiveDir string
+	// DefaultHeaders are added to every request sent by the recorder. If a
+	// request already has a value for one of the headers, the value on the
+	// request takes precedence.
+	DefaultHeaders http.Header
+	// DefaultQuery holds query parameters which are added to the URL of every
+	// request sent by the recorder. If a request already has a value for one
+	// of the parameters, the value on the request takes precedence.
+	DefaultQuery url.Values
+	// History holds every response recorded by the recorder, in the order the
+	// requests were sent.
+	History []*Response
+	// Now returns the current time. It is used by time-dependent assertions
+	// such as ExpectJSONFieldTimeRecent and for the timestamps of recorded
+	// responses. It can be replaced with a fixed clock to make such tests
+	// deterministic. Waiting (e.g. in Eventually) always uses the real clock.
+	// The default is time.Now.
+	Now func() time.Time
+	// SubtestMode causes each Expect method of a Response to be run as a
+	// separate subtest via t.Run, so that each assertion is reported
+	// individually by go test. The default is false.
+	SubtestMode bool
+	// Signer, if set, is called for every request sent by the recorder. The
+	// headers it returns are added to the request before it is sent. This can
+	// be used to sign requests, e.g. for AWS Signature Version 4.
+	Signer RequestSigner
+	// BodyTransformer, if set, is called with the body of every request sent
+	// by the recorder, and the body is replaced with the result. This can be
+	// used to encrypt or compress request bodies after they have been built.
+	// BodyTransformer is called before Signer. Any errors it returns will be
+	// passed to t.Fatal.
+	BodyTransformer func([]byte) ([]byte, error)
+	// HeaderInjectors are called for every request sent by the recorder with
+	// the context of the request and its headers, which they may modify. This
+	// can be used to propagate values from the context, such as distributed
+	// tracing headers (e.g. traceparent). See DoWithContext.
+	HeaderInjectors []HeaderInjector
+	// LogBodies causes the body of every response to be logged via t.Log,
+	// regardless of whether any assertions fail. If the Content-Type of the
+	// response is application/json, the body will be indented. The body is
+	// colorized according to Colorize. The default is false.
+	LogBodies bool
+	// FailFast causes failed assertions to be reported via t.Fatal instead of
+	// t.Error, which stops the test at the first failure. This is useful when
+	// later steps of a test are meaningless once an earlier step has failed.
+	// The default is false.
+	FailFast bool
+	// StrictJSON causes the JSON assertions (e.g. ExpectJSONObjectKeys) to
+	// check that the Content-Type of the response is JSON before decoding the
+	// body. If it is not, a clear message is passed to t.Fatal. Set StrictJSON
+	// to false for APIs which return JSON without the proper Content-Type. The
+	// default is true.
+	StrictJSON bool
+	// DebugErrorHeader is the name of the header checked by
+	// Response.ExpectNoDebugError. The default is X-Debug-Error.
+	DebugErrorHeader string
+	// ForbiddenHeaders are headers which indicate that something went wrong
+	// on the server, even if the response code does not. Every response
+	// recorded by the recorder is automatically checked, and a test error is
+	// reported for each forbidden header that is present and not empty.
+	ForbiddenHeaders []string
+	// SecurityHeaders are the headers checked by
+	// Response.ExpectSecurityHeaders. The default is DefaultSecurityHeaders.
+	SecurityHeaders []SecurityHeader
+	// CompareOptions determines how responses are compared by
+	// Response.ExpectSameAs. By default, no headers are compared and no JSON
+	// fields are ignored.
+	CompareOptions CompareOptions
+	// BodyFormatters convert request and response bodies into a more readable
+	// form when they are printed (e.g. by PrintFailure). They are keyed by
+	// media type. The default is DefaultBodyFormatters.
+	BodyFormatters map[string]BodyFormatter
+	// SchemaVersionPath is the dot-separated path of the JSON field checked by
+	// Response.ExpectSchemaVersion. The default is "version".
+	SchemaVersionPath string
+	// PreserveHeadersOnRedirect are headers which are copied from the original
+	// request to every redirected request, even if the redirect is to a
+	// different host. By default, the http package drops sensitive headers
+	// such as Authorization and Cookie when redirecting to a different host.
+	PreserveHeadersOnRedirect []string
+	// StripHeadersOnRedirect are headers which are removed from every
+	// redirected request, even if the redirect is to the same host.
+	StripHeadersOnRedirect []string
+	// Vars holds values which are substituted into requests sent by the
+	// recorder. Any reference to a variable in double braces (e.g.
+	// "/users/{{userId}}") in the path, query string, or body of a request is
+	// replaced with the value of the variable, formatted with fmt.Sprint.
+	// Only form, text, and JSON bodies are substituted, and values in JSON
+	// bodies are escaped as in a JSON string. This makes it possible to run
+	// the same tests against different environments. A reference to a
+	// variable which does not exist will be passed to t.Fatal.
+	Vars map[string]interface{}
+	// RecoverHandler causes any panics in the handler given to NewRecorder to
+	// be recovered and surfaced to the test. Normally the http server recovers
+	// from the panic itself and simply closes the connection, which hides what
+	// went wrong. When RecoverHandler is true, the panic is reported via
+	// t.Errorf along with its stack trace, a 500 response is sent instead, and
+	// the details of the panic are stored in the Panic field of the Response.
+	// RecoverHandler has no effect on recorders created with NewURLRecorder.
+	// The default is false.
+	RecoverHandler bool
+	// CaptureServerLogs causes any lines written to ServerLog while the handler
+	// given to NewRecorder is handling a request to be stored in the
+	// ServerLogs field of the Response. It has no effect on recorders created
+	// with NewURLRecorder. The default is false.
+	CaptureServerLogs bool
+	// Faults determines which faults (e.g. latency or failed requests) are
+	// injected into the requests sent by the recorder. Faults set on a
+	// recorder returned by Service only affect that recorder. By default, no
+	// faults are injected.
+	Faults FaultConfig
+	// ValidationErrorShape determines where Response.ExpectValidationError
+	// looks for validation errors in the body of an error response. The
+	// default is DefaultValidationErrorShape.
+	ValidationErrorShape ValidationErrorShape
 }
 
+// HeaderInjector adds headers derived from ctx to header.
+type HeaderInjector func(ctx context.Context, header http.Header)
+
+// RequestSigner computes the headers needed to sign a request. It receives the
+// method, url, and headers of the request along with a copy of the exact bytes
+// of the body, and returns the headers which should be added to the request.
+type RequestSigner func(method string, url *url.URL, header http.Header, body []byte) (http.Header, error)
+
 // NewRecorder returns a recorder that sends requests through the given handler.
 // The recorder will report any errors using t.Error or t.Fatal.
 func NewRecorder(t *testing.T, handler http.Handler) *Recorder {
-	server := httptest.NewServer(handler)
-	return &Recorder{
-		t:        t,
-		client:   newTestClient(t),
-		baseURL:  server.URL,
-		server:   server,
-		Colorize: true,
-	}
+	r := newRecorder(t, "")
+	r.server = httptest.NewServer(r.wrapHandler(handler))
+	r.baseURL = r.server.URL
+	return r
 }
 
 // NewURLRecorder creates a new recorder with the given baseURL. The recorder
 // will report any errors using t.Error or t.Fatal.
 func NewURLRecorder(t *testing.T, baseURL string) *Recorder {
-	return &Recorder{
-		t:        t,
-		client:   newTestClient(t),
-		baseURL:  baseURL,
-		Colorize: true,
+	return newRecorder(t, baseURL)
+}
+
+// newRecorder creates a new recorder with the given baseURL and the default
+// options.
+func newRecorder(t *testing.T, baseURL string) *Recorder {
+	r := &Recorder{
+		t:                    t,
+		transport:            newTestTransport(),
+		baseURL:              baseURL,
+		services:             map[string]string{},
+		handlerLog:           newHandlerLog(),
+		Colorize:             true,
+		LoginCode:            200,
+		DefaultHeaders:       http.Header{},
+		DefaultQuery:         url.Values{},
+		Now:                  time.Now,
+		StrictJSON:           true,
+		DebugErrorHeader:     "X-Debug-Error",
+		SecurityHeaders:      append([]SecurityHeader{}, DefaultSecurityHeaders...),
+		BodyFormatters:       map[string]BodyFormatter{},
+		SchemaVersionPath:    "version",
+		Vars:                 map[string]interface{}{},
+		ValidationErrorShape: DefaultValidationErrorShape,
 	}
+	for mediaType, formatter := range DefaultBodyFormatters {
+		r.BodyFormatters[mediaType] = formatter
+	}
+	r.client = r.newClient(newTestJar(t))
+	return r
 }
 
 // Close closes the recorder. You must call Close when you are done using a
@@ -63,6 +237,217 @@ func (r *Recorder) Close() {
 	}
 }
 
+// RegisterService registers a named service with the given baseURL, which can
+// then be accessed via Service. This is useful for integration tests which
+// span several services.
+func (r *Recorder) RegisterService(name string, baseURL string) {
+	r.services[name] = baseURL
+}
+
+// Service returns a recorder which sends requests to the service with the
+// given name, which must have been registered via RegisterService. The
+// returned recorder shares the cookie jar and transport of r, so cookies (e.g.
+// a session) set by one service are sent to the others as appropriate. Its
+// options are copied from r at the time Service is called, including copies of
+// all maps and slices such as DefaultHeaders and Vars, so changing them
+// (including via SetBearerToken, SetMaxRedirects, or SetCheckRedirect) only
+// affects the returned recorder. If no service with the given name has been
+// registered, the failure is passed to t.Fatal.
+func (r *Recorder) Service(name string) *Recorder {
+	baseURL, found := r.services[name]
+	if !found {
+		r.t.Fatalf("No service named %q has been registered.", name)
+	}
+	service := *r
+	service.baseURL = baseURL
+	service.server = nil
+	service.History = nil
+	service.services = map[string]string{}
+	for key, value := range r.services {
+		service.services[key] = value
+	}
+	service.DefaultHeaders = r.DefaultHeaders.Clone()
+	service.DefaultQuery = url.Values{}
+	for key, values := range r.DefaultQuery {
+		service.DefaultQuery[key] = append([]string{}, values...)
+	}
+	service.Vars = map[string]interface{}{}
+	for key, value := range r.Vars {
+		service.Vars[key] = value
+	}
+	service.BodyFormatters = map[string]BodyFormatter{}
+	for mediaType, formatter := range r.BodyFormatters {
+		service.BodyFormatters[mediaType] = formatter
+	}
+	service.HeaderInjectors = append([]HeaderInjector(nil), r.HeaderInjectors...)
+	service.ForbiddenHeaders = append([]string(nil), r.ForbiddenHeaders...)
+	service.SecurityHeaders = append([]SecurityHeader(nil), r.SecurityHeaders...)
+	service.PreserveHeadersOnRedirect = append([]string(nil), r.PreserveHeadersOnRedirect...)
+	service.StripHeadersOnRedirect = append([]string(nil), r.StripHeadersOnRedirect...)
+	service.CompareOptions.Headers = append([]string(nil), r.CompareOptions.Headers...)
+	service.CompareOptions.IgnorePaths = append([]string(nil), r.CompareOptions.IgnorePaths...)
+	service.client = service.newClient(r.client.Jar)
+	return &service
+}
+
+// SetMaxRedirects sets the maximum number of redirects the recorder will
+// follow for a single request. If a response would cause the recorder to
+// follow more than n redirects, a test error is reported (or t.Fatalf is called
+// if FailFast is true) and the last redirect response is returned instead of
+// following it.
+func (r *Recorder) SetMaxRedirects(n int) {
+	r.redirectPolicy = func(req *http.Request, via []*http.Request) error {
+		if len(via) > n {
+			r.errorf("%s request to %s failed: too many redirects (>%d)",
+				via[0].Method,
+				via[0].URL.Path,
+				n)
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+}
+
+// SetCheckRedirect sets the function which decides whether the recorder
+// follows each redirect. It has the same semantics as the CheckRedirect field
+// of http.Client, and replaces any limit set by SetMaxRedirects. fn is called
+// after PreserveHeadersOnRedirect and StripHeadersOnRedirect have been
+// applied, so it may make further changes to the headers of req.
+func (r *Recorder) SetCheckRedirect(fn func(req *http.Request, via []*http.Request) error) {
+	r.redirectPolicy = fn
+}
+
+// checkRedirect is the CheckRedirect function of the client used by the
+// recorder. It applies PreserveHeadersOnRedirect and StripHeadersOnRedirect to
+// req and then defers to r.redirectPolicy. If there is no redirectPolicy, it
+// behaves like the default policy of http.Client, which stops after 10
+// consecutive redirects.
+func (r *Recorder) checkRedirect(req *http.Request, via []*http.Request) error {
+	for _, name := range r.PreserveHeadersOnRedirect {
+		if values := via[0].Header.Values(name); len(values) > 0 {
+			req.Header[http.CanonicalHeaderKey(name)] = append([]string{}, values...)
+		}
+	}
+	for _, name := range r.StripHeadersOnRedirect {
+		req.Header.Del(name)
+	}
+	if r.redirectPolicy != nil {
+		return r.redirectPolicy(req, via)
+	}
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return nil
+}
+
+// SetDisableCompression can be used to turn off the transparent gzip
+// compression that is normally handled by the recorder. By default, the
+// recorder asks for a gzip-compressed response and automatically decompresses
+// it, hiding the Content-Encoding header. When compression is disabled, no
+// Accept-Encoding header is added and the body of the response is recorded
+// exactly as it was sent. The same is true for any individual request which
+// sets its own Accept-Encoding header.
+func (r *Recorder) SetDisableCompression(disable bool) {
+	r.transport.DisableCompression = disable
+}
+
+// SetProxy causes all requests sent by the recorder to be routed through the
+// proxy server at proxyURL. Any errors that occur while parsing proxyURL will
+// be passed to t.Fatal.
+func (r *Recorder) SetProxy(proxyURL string) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.transport.Proxy = http.ProxyURL(u)
+}
+
+// SetMaxIdleConns sets the maximum number of idle (keep-alive) connections
+// the recorder keeps open across all hosts. Zero means no limit.
+func (r *Recorder) SetMaxIdleConns(n int) {
+	r.transport.MaxIdleConns = n
+}
+
+// SetMaxIdleConnsPerHost sets the maximum number of idle (keep-alive)
+// connections the recorder keeps open for each host. Zero means the default
+// of http.DefaultMaxIdleConnsPerHost is used. This typically needs to be
+// increased when sending many concurrent requests.
+func (r *Recorder) SetMaxIdleConnsPerHost(n int) {
+	r.transport.MaxIdleConnsPerHost = n
+}
+
+// SetMaxConnsPerHost sets the maximum number of connections the recorder can
+// have open for each host at once, including connections which are in use.
+// Requests beyond the limit will wait for a connection to become available.
+// Zero means no limit. The limit of a transport can not be changed safely
+// once it has been used, so the recorder switches to a copy of its transport
+// with the new limit and closes the idle connections of the old one. Recorders
+// previously returned by Service keep using the old transport.
+func (r *Recorder) SetMaxConnsPerHost(n int) {
+	transport := r.transport.Clone()
+	transport.MaxConnsPerHost = n
+	r.transport.CloseIdleConnections()
+	r.transport = transport
+	r.client = r.newClient(r.client.Jar)
+}
+
+// SetAcceptLanguage sets the default Accept-Language header for all requests
+// sent by the recorder. To use a different language for a single request, set
+// the Accept-Language header on the request directly.
+func (r *Recorder) SetAcceptLanguage(lang string) {
+	r.DefaultHeaders.Set("Accept-Language", lang)
+}
+
+// SetDefaultQueryParam sets a query parameter which is added to the URL of
+// every request sent by the recorder (see DefaultQuery).
+func (r *Recorder) SetDefaultQueryParam(key, value string) {
+	r.DefaultQuery.Set(key, value)
+}
+
+// SetBearerToken sets the default Authorization header for all requests sent
+// by the recorder to "Bearer " followed by token.
+func (r *Recorder) SetBearerToken(token string) {
+	r.DefaultHeaders.Set("Authorization", "Bearer "+token)
+}
+
+// Reset restores the options which modify requests sent by the recorder to
+// their defaults. Specifically, it removes all DefaultHeaders (including any
+// Authorization header set by SetBearerToken or FetchOAuthToken and any
+// Accept-Language header set by SetAcceptLanguage), DefaultQuery, and Vars,
+// sets Signer, BodyTransformer, and HeaderInjectors to nil, and clears
+// History. The cookie jar is kept, so any session is remembered; use
+// ResetCookies to forget it. Timeouts are kept on purpose too: the recorder
+// never sets a timeout on its client, and deadlines for single requests are
+// set via the context passed to DoWithContext, so there is nothing to reset.
+// All other options, the baseURL, the settings of the underlying transport,
+// and any registered services are kept.
+func (r *Recorder) Reset() {
+	r.DefaultHeaders = http.Header{}
+	r.DefaultQuery = url.Values{}
+	r.Vars = map[string]interface{}{}
+	r.Signer = nil
+	r.BodyTransformer = nil
+	r.HeaderInjectors = nil
+	r.History = nil
+}
+
+// ResetCookies removes all cookies from the cookie jar, so that any session
+// is forgotten. The jar is shared with any recorders returned by Service, so
+// their cookies are removed as well.
+func (r *Recorder) ResetCookies() {
+	r.client.Jar.(*testJar).reset(r.t)
+}
+
+// errorf reports a failed assertion via t.Errorf, or via t.Fatalf if
+// r.FailFast is true.
+func (r *Recorder) errorf(format string, args ...interface{}) {
+	if r.FailFast {
+		r.t.Fatalf(format, args...)
+	} else {
+		r.t.Errorf(format, args...)
+	}
+}
+
 // newResponse creates and returns a *fipple.Response, which is a lightweight
 // wrapper around an *http.Response.
 func (r *Recorder) newResponse(resp *http.Response) *Response {
@@ -72,14 +457,72 @@ func (r *Recorder) newResponse(resp *http.Response) *Response {
 	}
 }
 
-// newTestClient returns an *http.Client with a cookiejar which can be used to
-// store and retrieve cookies.
-func newTestClient(t *testing.T) *http.Client {
+// newTestTransport returns an *http.Transport with the same settings as
+// http.DefaultTransport. Each recorder has its own transport so that its
+// settings can be changed without affecting other recorders.
+func newTestTransport() *http.Transport {
+	return http.DefaultTransport.(*http.Transport).Clone()
+}
+
+// testJar is the cookie jar of a recorder. It wraps a *cookiejar.Jar which
+// can be replaced by an empty one via reset.
+type testJar struct {
+	sync.Mutex
+	jar *cookiejar.Jar
+}
+
+// newTestJar returns a cookiejar which can be used to store and retrieve
+// cookies.
+func newTestJar(t *testing.T) http.CookieJar {
+	j := &testJar{}
+	j.reset(t)
+	return j
+}
+
+// reset replaces all cookies in j with an empty set.
+func (j *testJar) reset(t *testing.T) {
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return &http.Client{Jar: jar}
+	j.Lock()
+	j.jar = jar
+	j.Unlock()
+}
+
+// SetCookies implements the SetCookies method of http.CookieJar.
+func (j *testJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.Lock()
+	jar := j.jar
+	j.Unlock()
+	jar.SetCookies(u, cookies)
+}
+
+// Cookies implements the Cookies method of http.CookieJar.
+func (j *testJar) Cookies(u *url.URL) []*http.Cookie {
+	j.Lock()
+	jar := j.jar
+	j.Unlock()
+	return jar.Cookies(u)
+}
+
+// newClient returns an *http.Client which stores cookies in jar and sends
+// requests using r.transport, with transparent compression handled by a
+// compressionTransport. The CheckRedirect function, the tagTransport, and the
+// faultTransport of the client are bound to r, so that the redirect, fault, and
+// handler options of r only affect r.
+func (r *Recorder) newClient(jar http.CookieJar) *http.Client {
+	return &http.Client{
+		Jar: jar,
+		Transport: &tagTransport{
+			recorder: r,
+			next: &faultTransport{
+				recorder: r,
+				next:     &compressionTransport{transport: r.transport},
+			},
+		},
+		CheckRedirect: r.checkRedirect,
+	}
 }
 
 // NewRequest creates a new request object with the given http method and path.
@@ -113,6 +556,20 @@ func (r *Recorder) NewRequestWithData(method string, path string, data map[strin
 	return req
 }
 
+// NewMethodOverrideRequest is like NewRequestWithData, but the request is
+// sent with realMethod (usually POST) and has an X-HTTP-Method-Override header
+// set to overrideMethod. Servers and frameworks which honor the header will
+// treat the request as if it had been sent with overrideMethod. The header is
+// sent with exactly that casing rather than the canonical form
+// X-Http-Method-Override, since some servers only recognize the former. Any
+// errors that occur will be passed to t.Fatal.
+func (r *Recorder) NewMethodOverrideRequest(realMethod, overrideMethod, path string, data map[string]string) *http.Request {
+	req := r.NewRequestWithData(realMethod, path, data)
+	req.Header.Del("X-HTTP-Method-Override")
+	req.Header["X-HTTP-Method-Override"] = []string{overrideMethod}
+	return req
+}
+
 // NewMultipartRequest can be used to easily create (and later send)
 // a request with form data and/or files (encoded as multipart/form-data).
 // fields is a key-value map of basic string fields for the form data, and
@@ -121,14 +578,53 @@ func (r *Recorder) NewRequestWithData(method string, path string, data map[strin
 func (r *Recorder) NewMultipartRequest(method string, path string, fields map[string]string, files map[string]*os.File) *http.Request {
 	fullURL := r.baseURL + path
 
-	// First, create a new multipart form writer.
+	// First, create a new multipart form writer and write the form to it.
 	body := bytes.NewBuffer([]byte{})
 	form := multipart.NewWriter(body)
+	if err := writeMultipartForm(form, fields, files); err != nil {
+		r.t.Fatal(err)
+	}
+
+	// Create and return the request object
+	req, err := http.NewRequest(method, fullURL, body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "multipart/form-data; boundary="+form.Boundary())
+	return req
+}
+
+// NewStreamingMultipartRequest is like NewMultipartRequest, but instead of
+// buffering the entire form in memory, the form is written to the body of the
+// request as it is being sent. This keeps memory usage low regardless of the
+// size of the files. Because the size of the body is not known in advance, the
+// request is sent with chunked transfer encoding. Any errors that occur while
+// writing the form will cause the request to fail when it is sent.
+func (r *Recorder) NewStreamingMultipartRequest(method string, path string, fields map[string]string, files map[string]*os.File) *http.Request {
+	fullURL := r.baseURL + path
+	bodyReader, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	req, err := http.NewRequest(method, fullURL, bodyReader)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "multipart/form-data; boundary="+form.Boundary())
+
+	// Write the form in a separate goroutine. The pipe blocks until the
+	// request body is read, i.e. when the request is sent.
+	go func() {
+		bodyWriter.CloseWithError(writeMultipartForm(form, fields, files))
+	}()
+	return req
+}
 
+// writeMultipartForm writes the given fields and files to form and then closes
+// it.
+func writeMultipartForm(form *multipart.Writer, fields map[string]string, files map[string]*os.File) error {
 	// Add the key-value field params to the form
 	for fieldname, value := range fields {
 		if err := form.WriteField(fieldname, value); err != nil {
-			r.t.Fatal(err)
+			return err
 		}
 	}
 
@@ -136,25 +632,15 @@ func (r *Recorder) NewMultipartRequest(method string, path string, fields map[st
 	for fieldname, file := range files {
 		fileWriter, err := form.CreateFormFile(fieldname, file.Name())
 		if err != nil {
-			r.t.Fatal(err)
+			return err
 		}
 		if _, err := io.Copy(fileWriter, file); err != nil {
-			r.t.Fatal(err)
+			return err
 		}
 	}
 
 	// Close the form to finish writing
-	if err := form.Close(); err != nil {
-		r.t.Fatal(err)
-	}
-
-	// Create and return the request object
-	req, err := http.NewRequest(method, fullURL, body)
-	if err != nil {
-		r.t.Fatal(err)
-	}
-	req.Header.Add("Content-Type", "multipart/form-data; boundary="+form.Boundary())
-	return req
+	return form.Close()
 }
 
 // NewJSONRequest creates and returns a JSON request with the given
@@ -180,21 +666,268 @@ func (r *Recorder) NewJSONRequest(method string, path string, data interface{})
 	return req
 }
 
+// NewJSONRequestFromFile creates and returns a JSON request with the given
+// method and path (which is appended to the baseURL) whose body is read from
+// the file at fixturePath. Any references to variables in double braces
+// (e.g. "{{userId}}") in the file are replaced with the corresponding values
+// from vars, formatted with fmt.Sprint, and the result must be valid JSON.
+// The Content-Type header will automatically be set to application/json. Any
+// errors that occur, including a reference to a variable which is not in
+// vars, will be passed to t.Fatal.
+func (r *Recorder) NewJSONRequestFromFile(method string, path string, fixturePath string, vars map[string]interface{}) *http.Request {
+	data, err := ioutil.ReadFile(fixturePath)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	body := r.expandVarsFrom(string(data), vars)
+	if !json.Valid([]byte(body)) {
+		r.t.Fatalf("Expected %s to contain valid JSON after substituting variables but got:\n%s", fixturePath, body)
+	}
+
+	// Create and return the request object
+	fullURL := r.baseURL + path
+	req, err := http.NewRequest(method, fullURL, strings.NewReader(body))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	return req
+}
+
+// NewNDJSONRequest creates and returns a request with the given method and
+// path (which is appended to the baseURL) whose body is newline-delimited JSON.
+// Each item is converted into json using json.Marshal and written on its own
+// line. The Content-Type header will automatically be set to
+// application/x-ndjson. Any errors that occur will be passed to t.Fatal.
+func (r *Recorder) NewNDJSONRequest(method string, path string, items []interface{}) *http.Request {
+	// Create and write to the body. Encode adds a newline after each item.
+	body := bytes.NewBuffer([]byte{})
+	encoder := json.NewEncoder(body)
+	for _, item := range items {
+		if err := encoder.Encode(item); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+
+	// Create and return the request object
+	fullURL := r.baseURL + path
+	req, err := http.NewRequest(method, fullURL, body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", "application/x-ndjson")
+	return req
+}
+
+// NewRequestWithBody creates and returns a request with the given method and
+// path (which is appended to the baseURL) whose body is exactly the given
+// bytes. The Content-Type header will automatically be set to contentType.
+// This is useful for uploading binary data. Any errors that occur will be
+// passed to t.Fatal.
+func (r *Recorder) NewRequestWithBody(method string, path string, contentType string, body []byte) *http.Request {
+	fullURL := r.baseURL + path
+	req, err := http.NewRequest(method, fullURL, bytes.NewReader(body))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Header.Add("Content-Type", contentType)
+	return req
+}
+
 // Do sends req and records the results into a fipple.Response.
 // Note that because an http.Request should have already been created
 // with a full, valid url, the baseURL of the Recorder will not be prepended
 // to the url for req. You can run methods on the response to check
 // the results. Any errors that occur will be passed to t.Fatal
 func (r *Recorder) Do(req *http.Request) *Response {
+	r.prepareRequest(req)
+	start := r.Now()
+	return r.record(r.send(req), start)
+}
+
+// DoWithContext is like Do, but req is sent with the given context. Any
+// HeaderInjectors are called with ctx, and if ctx is canceled or its deadline
+// is exceeded before the response is received, the error is passed to
+// t.Fatal.
+func (r *Recorder) DoWithContext(ctx context.Context, req *http.Request) *Response {
+	return r.Do(req.WithContext(ctx))
+}
+
+// ExpectTimeout sends req and causes a test error unless it times out, i.e.
+// no response is received within the given duration. This is useful for
+// testing deliberately slow endpoints. Unlike Do, errors caused by the timeout
+// are expected and are not passed to t.Fatal. Any other errors cause a test
+// error.
+func (r *Recorder) ExpectTimeout(req *http.Request, within time.Duration) {
+	ctx, cancel := context.WithTimeout(req.Context(), within)
+	defer cancel()
+	req = req.WithContext(ctx)
+	r.prepareRequest(req)
 	httpResp, err := r.client.Do(req)
-	if err != nil {
-		r.t.Fatal(err)
+	if err == nil {
+		httpResp.Body.Close()
+		r.errorf("Expected %s request to %s to time out within %s but got response code: %d",
+			req.Method,
+			req.URL.Path,
+			within,
+			httpResp.StatusCode)
+		return
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		r.errorf("Expected %s request to %s to time out within %s but got error: %s",
+			req.Method,
+			req.URL.Path,
+			within,
+			err)
+	}
+}
+
+// prepareRequest applies the options of the recorder which modify requests
+// (e.g. DefaultHeaders and Signer) to req.
+func (r *Recorder) prepareRequest(req *http.Request) {
+	r.substituteVars(req)
+	r.addDefaultHeaders(req)
+	r.addDefaultQuery(req)
+	for _, inject := range r.HeaderInjectors {
+		inject(req.Context(), req.Header)
 	}
+	r.transformBody(req)
+	r.signRequest(req)
+}
+
+// record reads the body of httpResp and records the result into a
+// fipple.Response. start is the time at which the request was sent. Any
+// errors that occur will be passed to t.Fatal
+func (r *Recorder) record(httpResp *http.Response, start time.Time) *Response {
 	resp := r.newResponse(httpResp)
 	resp.readBody()
+	if body, ok := httpResp.Body.(*gzipBody); ok {
+		resp.wireSize = body.wire.n
+	}
+	resp.startTime = start
+	resp.duration = r.Now().Sub(start)
+	r.recordHandlerLog(resp)
+	if r.ResponseArchiveDir != "" {
+		resp.archive()
+	}
+	if r.LogBodies {
+		resp.logBody()
+	}
+	for _, name := range r.ForbiddenHeaders {
+		resp.ExpectNoHeader(name)
+	}
+	r.History = append(r.History, resp)
 	return resp
 }
 
+// addDefaultHeaders adds r.DefaultHeaders to req, skipping any headers which
+// req already has.
+func (r *Recorder) addDefaultHeaders(req *http.Request) {
+	for key, values := range r.DefaultHeaders {
+		if _, found := req.Header[key]; found {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+// transformBody replaces the body of req with the result of calling
+// r.BodyTransformer (if any). Any errors that occur will be passed to t.Fatal
+func (r *Recorder) transformBody(req *http.Request) {
+	if r.BodyTransformer == nil || req.Body == nil {
+		return
+	}
+	body, err := r.BodyTransformer(r.readRequestBody(req))
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	setRequestBody(req, body)
+}
+
+// signRequest adds the headers returned by r.Signer (if any) to req. Any
+// errors that occur will be passed to t.Fatal
+func (r *Recorder) signRequest(req *http.Request) {
+	if r.Signer == nil {
+		return
+	}
+	body := r.readRequestBody(req)
+	header, err := r.Signer(req.Method, req.URL, req.Header.Clone(), body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+// addDefaultQuery adds r.DefaultQuery to the end of the URL of req, skipping
+// any parameters which the URL already has. The existing query string is left
+// exactly as it was.
+func (r *Recorder) addDefaultQuery(req *http.Request) {
+	if len(r.DefaultQuery) == 0 {
+		return
+	}
+	query := req.URL.Query()
+	defaults := url.Values{}
+	for key, values := range r.DefaultQuery {
+		if _, found := query[key]; found {
+			continue
+		}
+		defaults[key] = values
+	}
+	if len(defaults) == 0 {
+		return
+	}
+	if req.URL.RawQuery != "" {
+		req.URL.RawQuery += "&"
+	}
+	req.URL.RawQuery += defaults.Encode()
+}
+
+// send sends req and returns the resulting *http.Response. If r.ReplayMode is
+// set, the response may be served from (or saved to) r.FixturesDir instead.
+// Any errors that occur will be passed to t.Fatal
+func (r *Recorder) send(req *http.Request) *http.Response {
+	if r.ReplayMode != ReplayOff {
+		return r.replay(req)
+	}
+	httpResp, err := r.client.Do(req)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return httpResp
+}
+
+// readRequestBody reads and returns the body of req. The body of req is
+// replaced so that it can still be read when req is sent. Any errors that
+// occur will be passed to t.Fatal
+func (r *Recorder) readRequestBody(req *http.Request) []byte {
+	if req.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req.Body.Close()
+	setRequestBody(req, body)
+	return body
+}
+
+// setRequestBody replaces the body of req with body.
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+}
+
 // Get sends a GET request to the given path and records the results into
 // a fipple.Response. path will be appended to the baseURL for the recorder
 // to create the full URL. You can run methods on the response to check the
@@ -233,6 +966,179 @@ func (r *Recorder) Delete(path string) *Response {
 	return r.Do(req)
 }
 
+// Options sends an OPTIONS request to the given path and records the results
+// into a fipple.Response. path will be appended to the baseURL for the
+// recorder to create the full URL. You can run methods on the response to
+// check the results. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) Options(path string) *Response {
+	req := r.NewRequest("OPTIONS", path)
+	return r.Do(req)
+}
+
+// GetRange sends a GET request to the given path with a Range header for the
+// bytes from start to end (inclusive), and records the results into a
+// fipple.Response. The response can be checked with ExpectPartialContent.
+// path will be appended to the baseURL for the recorder to create the full
+// URL. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) GetRange(path string, start, end int64) *Response {
+	req := r.NewRequest("GET", path)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	return r.Do(req)
+}
+
+// GetWith is like Get, but modify is called with the request before it is
+// sent. This makes it possible to adjust the headers or cookies of the request
+// without building it by hand.
+func (r *Recorder) GetWith(path string, modify func(*http.Request)) *Response {
+	req := r.NewRequest("GET", path)
+	modify(req)
+	return r.Do(req)
+}
+
+// PostWith is like Post, but modify is called with the request before it is
+// sent.
+func (r *Recorder) PostWith(path string, data map[string]string, modify func(*http.Request)) *Response {
+	req := r.NewRequestWithData("POST", path, data)
+	modify(req)
+	return r.Do(req)
+}
+
+// PutWith is like Put, but modify is called with the request before it is
+// sent.
+func (r *Recorder) PutWith(path string, data map[string]string, modify func(*http.Request)) *Response {
+	req := r.NewRequestWithData("PUT", path, data)
+	modify(req)
+	return r.Do(req)
+}
+
+// DeleteWith is like Delete, but modify is called with the request before it
+// is sent.
+func (r *Recorder) DeleteWith(path string, modify func(*http.Request)) *Response {
+	req := r.NewRequest("DELETE", path)
+	modify(req)
+	return r.Do(req)
+}
+
+// ExpectEndpoints sends a GET request to each of the paths in expectations
+// and causes a test error for each response whose code is not the
+// corresponding value. The paths are requested in sorted order, and every
+// path is requested even if an earlier one fails (unless FailFast is true).
+// This is useful for smoke-testing a whole route table. Any errors that occur
+// will be passed to t.Fatal
+func (r *Recorder) ExpectEndpoints(expectations map[string]int) {
+	paths := []string{}
+	for path := range expectations {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		r.Get(path).ExpectCode(expectations[path])
+	}
+}
+
+// ConditionalGet tests that the resource at the given path can be cached
+// using an ETag. It sends a GET request to the path, then sends a second GET
+// request with an If-None-Match header set to the ETag of the first response.
+// A test error is reported if the first response does not have an ETag or if
+// the second response does not have the code 304 and an empty body. The
+// second response is returned, unless the first response had no ETag, in
+// which case the first response is returned. Any errors that occur will be
+// passed to t.Fatal
+func (r *Recorder) ConditionalGet(path string) *Response {
+	first := r.Get(path)
+	etag := first.Header.Get("ETag")
+	if etag == "" {
+		first.PrintFailureOnce()
+		first.errorf("Expected response to have an ETag header but it did not.")
+		return first
+	}
+	req := r.NewRequest("GET", path)
+	req.Header.Set("If-None-Match", etag)
+	second := r.Do(req)
+	second.ExpectCode(http.StatusNotModified)
+	if len(second.RawBody) != 0 {
+		second.PrintFailureOnce()
+		second.errorf("Expected 304 response to have an empty body but it did not.")
+	}
+	return second
+}
+
+// ConditionalGetSince sends a GET request to the given path with an
+// If-Modified-Since header set to since, formatted as an HTTP-date. This can
+// be used to test caching based on Last-Modified. Unlike ConditionalGet, the
+// response is not checked, since either a 304 or a 200 may be expected
+// depending on since. path will be appended to the baseURL for the recorder
+// to create the full URL. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) ConditionalGetSince(path string, since time.Time) *Response {
+	req := r.NewRequest("GET", path)
+	req.Header.Set("If-Modified-Since", since.UTC().Format(http.TimeFormat))
+	return r.Do(req)
+}
+
+// GetAllPages sends a GET request to the given path, followed by a GET
+// request for each subsequent page of results, and returns the bodies of all
+// the pages in order. After each response, nextPath is called to determine
+// the path of the next page (e.g. from a "next" link or cursor in the
+// response). The path may be relative to the baseURL or a full URL. Requests
+// stop when nextPath returns an empty string. Any errors that occur will be
+// passed to t.Fatal
+func (r *Recorder) GetAllPages(path string, nextPath func(resp *Response) string) [][]byte {
+	pages := [][]byte{}
+	for path != "" {
+		var req *http.Request
+		if u, err := url.Parse(path); err == nil && u.IsAbs() {
+			req, err = http.NewRequest("GET", path, nil)
+			if err != nil {
+				r.t.Fatal(err)
+			}
+		} else {
+			req = r.NewRequest("GET", path)
+		}
+		res := r.Do(req)
+		pages = append(pages, res.Body)
+		path = nextPath(res)
+	}
+	return pages
+}
+
+// Login sends a POST request to the given path using credentials as post
+// parameters and expects the response code to be r.LoginCode. Any cookies set
+// by the response (e.g. a session cookie) are stored by the recorder and sent
+// with subsequent requests. path will be appended to the baseURL for the
+// recorder to create the full URL. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) Login(path string, credentials map[string]string) *Response {
+	res := r.Post(path, credentials)
+	res.ExpectCode(r.LoginCode)
+	return res
+}
+
+// FetchOAuthToken obtains an OAuth2 access token by sending a POST request to
+// tokenPath with the given form data (e.g. grant_type, client_id, and
+// client_secret). The access_token from the JSON response is set as the bearer
+// token for all subsequent requests (see SetBearerToken) and returned. If the
+// response is not successful or does not contain an access token, the
+// failure is passed to t.Fatal.
+func (r *Recorder) FetchOAuthToken(tokenPath string, form map[string]string) string {
+	res := r.Post(tokenPath, form)
+	if ok, msg := res.CheckOk2xx(); !ok {
+		res.PrintFailureOnce()
+		r.t.Fatal(msg)
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := res.Unmarshal(&token); err != nil {
+		r.t.Fatal(err)
+	}
+	if token.AccessToken == "" {
+		res.PrintFailureOnce()
+		r.t.Fatal("Expected token response to contain an access_token but it did not.")
+	}
+	r.SetBearerToken(token.AccessToken)
+	return token.AccessToken
+}
+
 // GetCookies returns the raw cookies that have been set as a result
 // of any requests recorded by a Recorder. Any errors that occur will be
 // passed to t.Fatal
@@ -243,3 +1149,76 @@ func (r *Recorder) GetCookies() []*http.Cookie {
 	}
 	return r.client.Jar.Cookies(fullURL)
 }
+
+// GetCookie returns the cookie with the given name that has been set as a
+// result of any requests recorded by a Recorder, or nil if there is no such
+// cookie. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) GetCookie(name string) *http.Cookie {
+	for _, cookie := range r.GetCookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// CookieValue returns the value of the cookie with the given name that has
+// been set as a result of any requests recorded by a Recorder, or an empty
+// string if there is no such cookie. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) CookieValue(name string) string {
+	if cookie := r.GetCookie(name); cookie != nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// ExpectJarCookies causes a test error unless the cookies that have been set
+// as a result of any requests recorded by a Recorder are exactly the cookies
+// with the given names, no more and no fewer. Any missing and extra cookies
+// are reported in a single error. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) ExpectJarCookies(names ...string) {
+	expected := map[string]bool{}
+	for _, name := range names {
+		expected[name] = true
+	}
+	actual := map[string]bool{}
+	extra := []string{}
+	for _, cookie := range r.GetCookies() {
+		actual[cookie.Name] = true
+		if !expected[cookie.Name] {
+			extra = append(extra, cookie.Name)
+		}
+	}
+	missing := []string{}
+	for _, name := range names {
+		if !actual[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 || len(extra) > 0 {
+		sort.Strings(extra)
+		r.errorf("Expected cookie jar to contain exactly %v but it was missing %v and had extra %v", names, missing, extra)
+	}
+}
+
+// Eventually calls fn repeatedly, waiting interval between each call, until
+// fn returns true or timeout has elapsed. If fn does not return true before
+// the timeout, a test error is reported. fn typically sends a request and
+// checks the response. Since any test errors reported by fn are not undone by
+// later calls, fn should inspect the response with the Check methods (e.g.
+// CheckCode) rather than the Expect methods.
+func (r *Recorder) Eventually(timeout, interval time.Duration, fn func(r *Recorder) bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		if fn(r) {
+			return
+		}
+		if !time.Now().Add(interval).Before(deadline) {
+			r.errorf("Condition was not met within %s.", timeout)
+			return
+		}
+		time.Sleep(interval)
+	}
+}
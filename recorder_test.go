@@ -0,0 +1,782 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failed calls fn with a *testing.T which is not part of the test run and
+// returns true iff fn caused a test failure. This makes it possible to test
+// that assertions fail when they should. fn may call t.Fatal, but it must not
+// use methods such as t.Run or t.TempDir.
+func failed(fn func(t *testing.T)) bool {
+	t := &testing.T{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(t)
+	}()
+	<-done
+	return t.Failed()
+}
+
+// failureOutput runs the current test again in a subprocess, in which only fn
+// is called, and returns the output of the subprocess and true iff fn caused a
+// test failure. Unlike failed, this makes it possible to test the messages
+// reported by assertions. It can only be called once per test.
+func failureOutput(t *testing.T, fn func(t *testing.T)) (string, bool) {
+	if os.Getenv("FIPPLE_SUBPROCESS_TEST") == t.Name() {
+		fn(t)
+		t.SkipNow()
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$")
+	cmd.Env = append(os.Environ(), "FIPPLE_SUBPROCESS_TEST="+t.Name())
+	output, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+	return string(output), err != nil
+}
+
+// echoAuthHandler responds with the Authorization and X-Foo headers of each
+// request.
+var echoAuthHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("auth=" + req.Header.Get("Authorization") + ";foo=" + req.Header.Get("X-Foo")))
+})
+
+// redirectHandler redirects every request to target.
+func redirectHandler(target string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, target, http.StatusFound)
+	})
+}
+
+func TestRedirectHeadersCrossHost(t *testing.T) {
+	target := httptest.NewServer(echoAuthHandler)
+	defer target.Close()
+	// 127.0.0.1 and localhost are different hosts as far as the http package
+	// is concerned.
+	otherHost := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	rec := NewRecorder(t, redirectHandler(otherHost+"/"))
+	defer rec.Close()
+	rec.SetBearerToken("secret")
+	rec.DefaultHeaders.Set("X-Foo", "bar")
+
+	rec.Get("/").ExpectBodyEquals("auth=;foo=bar")
+
+	rec.PreserveHeadersOnRedirect = []string{"Authorization"}
+	rec.StripHeadersOnRedirect = []string{"X-Foo"}
+	rec.Get("/").ExpectBodyEquals("auth=Bearer secret;foo=")
+}
+
+func TestServiceRedirectOptions(t *testing.T) {
+	target := httptest.NewServer(echoAuthHandler)
+	defer target.Close()
+	otherHost := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	redirector := httptest.NewServer(redirectHandler(otherHost + "/"))
+	defer redirector.Close()
+	loop := httptest.NewServer(redirectHandler("/"))
+	defer loop.Close()
+
+	rec := NewURLRecorder(t, redirector.URL)
+	rec.RegisterService("redirector", redirector.URL)
+	rec.RegisterService("loop", loop.URL)
+	rec.SetBearerToken("secret")
+
+	service := rec.Service("redirector")
+	service.PreserveHeadersOnRedirect = []string{"Authorization"}
+	service.Get("/").ExpectBodyEquals("auth=Bearer secret;foo=")
+	// The options of the service must not affect the parent recorder.
+	rec.Get("/").ExpectBodyEquals("auth=;foo=")
+
+	limited := rec.Service("loop")
+	limited.SetMaxRedirects(2)
+	if !failed(func(t *testing.T) {
+		limited.t = t
+		limited.Get("/")
+	}) {
+		t.Error("Expected SetMaxRedirects on a service recorder to limit redirects")
+	}
+}
+
+// countdownHandler redirects a request to /n to /n-1 until it reaches /0,
+// which responds with "done". hits is incremented for each request.
+func countdownHandler(hits *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		*hits++
+		n, err := strconv.Atoi(strings.TrimPrefix(req.URL.Path, "/"))
+		if err != nil || n <= 0 {
+			w.Write([]byte("done"))
+			return
+		}
+		http.Redirect(w, req, "/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+}
+
+func TestSetMaxRedirects(t *testing.T) {
+	hits := 0
+	rec := NewRecorder(t, countdownHandler(&hits))
+	defer rec.Close()
+	rec.SetMaxRedirects(3)
+
+	rec.Get("/3").ExpectBodyEquals("done")
+	if hits != 4 {
+		t.Errorf("Expected 4 requests to follow 3 redirects but got %d", hits)
+	}
+
+	output, failed := failureOutput(t, func(t *testing.T) {
+		rec.t = t
+		rec.Get("/4")
+	})
+	if !failed {
+		t.Fatalf("Expected too many redirects to fail the test but got:\n%s", output)
+	}
+	if expected := "GET request to /4 failed: too many redirects (>3)"; !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q but got:\n%s", expected, output)
+	}
+}
+
+func TestSetMaxRedirectsLastResponse(t *testing.T) {
+	hits := 0
+	rec := NewRecorder(t, countdownHandler(&hits))
+	defer rec.Close()
+	rec.SetMaxRedirects(3)
+
+	var res *Response
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		res = rec.Get("/4")
+	}) {
+		t.Fatal("Expected too many redirects to fail the test")
+	}
+	rec.t = t
+	// The fourth redirect is not followed, so the response is the redirect
+	// from /1 to /0.
+	res.ExpectCode(http.StatusFound)
+	if location := res.Header.Get("Location"); location != "/0" {
+		t.Errorf("Expected Location to be /0 but got %q", location)
+	}
+	if hits != 4 {
+		t.Errorf("Expected 4 requests before the limit was reached but got %d", hits)
+	}
+}
+
+// sessionHandler sets a session cookie in response to a POST to /login with
+// the right credentials, and responds to /me with the name of the logged-in
+// user if the request has the session cookie.
+var sessionHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/login":
+		if req.PostFormValue("username") != "alice" || req.PostFormValue("password") != "secret" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "alice-session", Path: "/"})
+		w.Write([]byte("logged in"))
+	case "/me":
+		if cookie, err := req.Cookie("session"); err != nil || cookie.Value != "alice-session" {
+			http.Error(w, "not logged in", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("alice"))
+	}
+})
+
+func TestLogin(t *testing.T) {
+	rec := NewRecorder(t, sessionHandler)
+	defer rec.Close()
+
+	rec.Get("/me").ExpectCode(http.StatusUnauthorized)
+	rec.Login("/login", map[string]string{"username": "alice", "password": "secret"}).
+		ExpectBodyEquals("logged in")
+	if cookie := rec.GetCookie("session"); cookie == nil || cookie.Value != "alice-session" {
+		t.Errorf("Expected session cookie to be stored but got %v", cookie)
+	}
+	rec.Get("/me").ExpectBodyEquals("alice")
+}
+
+func TestLoginFailure(t *testing.T) {
+	rec := NewRecorder(t, sessionHandler)
+	defer rec.Close()
+
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Login("/login", map[string]string{"username": "alice", "password": "wrong"})
+	}) {
+		t.Error("Expected Login with the wrong password to fail the test")
+	}
+	rec.t = t
+	if cookie := rec.GetCookie("session"); cookie != nil {
+		t.Errorf("Expected no session cookie after a failed login but got %v", cookie)
+	}
+	rec.Get("/me").ExpectCode(http.StatusUnauthorized)
+
+	// A custom LoginCode is honored.
+	rec.LoginCode = http.StatusUnauthorized
+	rec.Login("/login", map[string]string{"username": "alice", "password": "wrong"})
+}
+
+func TestSetProxy(t *testing.T) {
+	var proxied *http.Request
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		proxied = req
+		w.Write([]byte("via proxy"))
+	}))
+	defer proxy.Close()
+
+	// The host does not exist, so the request can only succeed if it is sent
+	// to the proxy.
+	rec := NewURLRecorder(t, "http://api.example.invalid")
+	rec.SetProxy(proxy.URL)
+	rec.Get("/users?id=1").ExpectBodyEquals("via proxy")
+	if proxied == nil {
+		t.Fatal("Expected request to be sent to the proxy")
+	}
+	if expected := "http://api.example.invalid/users?id=1"; proxied.RequestURI != expected {
+		t.Errorf("Expected proxy to receive request for %s but got %s", expected, proxied.RequestURI)
+	}
+	if proxied.Host != "api.example.invalid" {
+		t.Errorf("Expected Host to be api.example.invalid but got %s", proxied.Host)
+	}
+
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.SetProxy("://not a url")
+	}) {
+		t.Error("Expected SetProxy with an invalid URL to fail the test")
+	}
+}
+
+func TestNewStreamingMultipartRequest(t *testing.T) {
+	const size = 16 << 20
+	path := filepath.Join(t.TempDir(), "upload.bin")
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength != -1 || len(req.TransferEncoding) == 0 || req.TransferEncoding[0] != "chunked" {
+			http.Error(w, fmt.Sprintf("expected chunked body but got Content-Length %d and Transfer-Encoding %v", req.ContentLength, req.TransferEncoding), http.StatusBadRequest)
+			return
+		}
+		reader, err := req.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			hash := sha256.New()
+			n, err := io.Copy(hash, part)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if part.FileName() == "" {
+				fmt.Fprintf(w, "%s=%d;", part.FormName(), n)
+			} else {
+				fmt.Fprintf(w, "%s=%d:%x;", part.FormName(), n, hash.Sum(nil))
+			}
+		}
+	}))
+	defer rec.Close()
+
+	req := rec.NewStreamingMultipartRequest("POST", "/upload", map[string]string{"name": "alice"}, map[string]*os.File{"file": file})
+	res := rec.Do(req)
+	res.ExpectOk()
+	res.ExpectBodyEquals(fmt.Sprintf("name=5;file=%d:%x;", size, sha256.Sum256(data)))
+}
+
+func TestNewStreamingMultipartRequestError(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	file, err := os.Open(os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A file which cannot be read causes the request to fail when it is sent.
+	file.Close()
+	req := rec.NewStreamingMultipartRequest("POST", "/upload", nil, map[string]*os.File{"file": file})
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Do(req)
+	}) {
+		t.Error("Expected sending a form with an unreadable file to fail the test")
+	}
+}
+
+// echoHeaderHandler responds with all the values of the given request header,
+// separated by commas.
+func echoHeaderHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(strings.Join(req.Header.Values(name), ",")))
+	})
+}
+
+func TestSetAcceptLanguage(t *testing.T) {
+	rec := NewRecorder(t, echoHeaderHandler("Accept-Language"))
+	defer rec.Close()
+
+	rec.Get("/").ExpectBodyEquals("")
+	rec.SetAcceptLanguage("fr-CH, fr;q=0.9")
+	rec.Get("/").ExpectBodyEquals("fr-CH, fr;q=0.9")
+	rec.Post("/", nil).ExpectBodyEquals("fr-CH, fr;q=0.9")
+
+	// A value set on the request overrides the default.
+	rec.GetWith("/", func(req *http.Request) {
+		req.Header.Set("Accept-Language", "de")
+	}).ExpectBodyEquals("de")
+	rec.Get("/").ExpectBodyEquals("fr-CH, fr;q=0.9")
+}
+
+func TestDefaultHeadersPrecedence(t *testing.T) {
+	rec := NewRecorder(t, echoHeaderHandler("X-Tenant"))
+	defer rec.Close()
+	rec.DefaultHeaders.Add("X-Tenant", "default-a")
+	rec.DefaultHeaders.Add("X-Tenant", "default-b")
+
+	rec.Get("/").ExpectBodyEquals("default-a,default-b")
+	rec.Post("/", nil).ExpectBodyEquals("default-a,default-b")
+
+	// A header on the request replaces all the default values rather than
+	// being added to them.
+	rec.GetWith("/", func(req *http.Request) {
+		req.Header.Set("X-Tenant", "request")
+	}).ExpectBodyEquals("request")
+	rec.PostWith("/", nil, func(req *http.Request) {
+		req.Header.Add("x-tenant", "request-a")
+		req.Header.Add("X-TENANT", "request-b")
+	}).ExpectBodyEquals("request-a,request-b")
+
+	// The defaults themselves are not modified.
+	rec.Get("/").ExpectBodyEquals("default-a,default-b")
+}
+
+func TestNewNDJSONRequest(t *testing.T) {
+	var lines []string
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lines = strings.Split(string(body), "\n")
+		w.Write([]byte(req.Header.Get("Content-Type")))
+	}))
+	defer rec.Close()
+
+	items := []interface{}{
+		map[string]interface{}{"index": map[string]string{"_id": "1"}},
+		map[string]interface{}{"text": "spans\nlines"},
+		"plain string",
+		42,
+	}
+	rec.Do(rec.NewNDJSONRequest("POST", "/_bulk", items)).ExpectBodyEquals("application/x-ndjson")
+
+	// The body ends with a newline, so the last line is blank.
+	if len(lines) != len(items)+1 || lines[len(lines)-1] != "" {
+		t.Fatalf("Expected %d lines followed by a blank line but got %q", len(items), lines)
+	}
+	for i, line := range lines[:len(items)] {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(line), &decoded); err != nil {
+			t.Errorf("Expected line %d to be valid JSON but got %q: %s", i, line, err)
+			continue
+		}
+		if actual, expected := jsonString(decoded), jsonString(items[i]); actual != expected {
+			t.Errorf("Expected line %d to be %s but got %s", i, expected, actual)
+		}
+	}
+
+	// Items which can not be encoded as a single line of JSON fail the test.
+	for _, item := range []interface{}{json.RawMessage("{not json"), make(chan int)} {
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			rec.NewNDJSONRequest("POST", "/_bulk", []interface{}{"ok", item})
+		}) {
+			t.Errorf("Expected NewNDJSONRequest with item %#v to fail the test", item)
+		}
+	}
+}
+
+// hmacSignature returns the hex-encoded HMAC-SHA256 of the method, path, and
+// body of a request.
+func hmacSignature(method string, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte("signing-key"))
+	mac.Write([]byte(method + "\n" + path + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hmacSigner is a RequestSigner which signs requests with hmacSignature.
+func hmacSigner(method string, u *url.URL, header http.Header, body []byte) (http.Header, error) {
+	return http.Header{"X-Signature": {hmacSignature(method, u.Path, body)}}, nil
+}
+
+// verifySignatureHandler responds with the body of each request if the
+// X-Signature header matches the body received, or 401 otherwise.
+var verifySignatureHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if expected := hmacSignature(req.Method, req.URL.Path, body); req.Header.Get("X-Signature") != expected {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	w.Write(body)
+})
+
+func TestSigner(t *testing.T) {
+	rec := NewRecorder(t, verifySignatureHandler)
+	defer rec.Close()
+
+	rec.Post("/sign", map[string]string{"a": "1"}).ExpectCode(http.StatusUnauthorized)
+	rec.Signer = hmacSigner
+	rec.Post("/sign", map[string]string{"a": "1"}).ExpectBodyEquals("a=1")
+	rec.Get("/sign").ExpectOk()
+
+	// The signature must be computed over the body after BodyTransformer.
+	rec.BodyTransformer = func(body []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(body)), nil
+	}
+	rec.Post("/sign", map[string]string{"a": "1"}).ExpectBodyEquals(base64.StdEncoding.EncodeToString([]byte("a=1")))
+
+	rec.Signer = func(string, *url.URL, http.Header, []byte) (http.Header, error) {
+		return nil, errors.New("no signing key")
+	}
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Get("/sign")
+	}) {
+		t.Error("Expected an error from Signer to fail the test")
+	}
+}
+
+// concurrencyHandler counts the number of requests being handled at once and
+// records the highest count in max.
+type concurrencyHandler struct {
+	sync.Mutex
+	current int
+	max     int
+}
+
+// ServeHTTP implements http.Handler.
+func (h *concurrencyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.Lock()
+	h.current++
+	if h.current > h.max {
+		h.max = h.current
+	}
+	h.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	h.Lock()
+	h.current--
+	h.Unlock()
+	w.Write([]byte("ok"))
+}
+
+// sendConcurrently sends n GET requests to / at the same time, each with a
+// separate service recorder of rec so that they share its transport.
+func sendConcurrently(rec *Recorder, n int) {
+	services := []*Recorder{}
+	for i := 0; i < n; i++ {
+		services = append(services, rec.Service("self"))
+	}
+	wg := sync.WaitGroup{}
+	for _, service := range services {
+		wg.Add(1)
+		go func(service *Recorder) {
+			defer wg.Done()
+			service.Get("/").ExpectOk()
+		}(service)
+	}
+	wg.Wait()
+}
+
+func TestSetMaxConnsPerHost(t *testing.T) {
+	handler := &concurrencyHandler{}
+	rec := NewRecorder(t, handler)
+	defer rec.Close()
+	rec.RegisterService("self", rec.server.URL)
+
+	sendConcurrently(rec, 5)
+	if handler.max < 2 {
+		t.Fatalf("Expected requests to be handled concurrently without a limit but the most at once was %d", handler.max)
+	}
+
+	handler.max = 0
+	rec.SetMaxConnsPerHost(1)
+	sendConcurrently(rec, 5)
+	if handler.max != 1 {
+		t.Errorf("Expected at most 1 request to be handled at once but got %d", handler.max)
+	}
+}
+
+func TestBodyTransformer(t *testing.T) {
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%d:%d:%s", req.ContentLength, len(body), body)
+	}))
+	defer rec.Close()
+	rec.BodyTransformer = func(body []byte) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(body)), nil
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte("name=alice"))
+	res := rec.Post("/", map[string]string{"name": "alice"})
+	res.ExpectBodyEquals(fmt.Sprintf("%d:%d:%s", len(encoded), len(encoded), encoded))
+
+	// Requests without a body are not transformed.
+	rec.Get("/").ExpectBodyEquals("0:0:")
+
+	rec.BodyTransformer = func([]byte) ([]byte, error) {
+		return nil, errors.New("transform failed")
+	}
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Post("/", map[string]string{"name": "alice"})
+	}) {
+		t.Error("Expected an error from BodyTransformer to fail the test")
+	}
+}
+
+func TestDefaultQuery(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	rec.SetDefaultQueryParam("api_key", "secret")
+	rec.DefaultQuery.Add("tag", "a")
+	rec.DefaultQuery.Add("tag", "b")
+
+	rec.Get("/").ExpectBodyEquals("/?api_key=secret&tag=a&tag=b\n")
+	rec.Post("/", map[string]string{"name": "alice"}).ExpectBodyEquals("/?api_key=secret&tag=a&tag=b\nname=alice")
+
+	// Values on the request take precedence, and the order of the existing
+	// parameters is kept.
+	rec.Get("/?z=1&api_key=mine&a=2").ExpectBodyEquals("/?z=1&api_key=mine&a=2&tag=a&tag=b\n")
+	rec.Post("/?tag=c", nil).ExpectBodyEquals("/?tag=c&api_key=secret\n")
+	rec.Get("/?api_key=mine&tag=c").ExpectBodyEquals("/?api_key=mine&tag=c\n")
+}
+
+func TestConditionalGetSince(t *testing.T) {
+	modified := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	var ifModifiedSince string
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ifModifiedSince = req.Header.Get("If-Modified-Since")
+		http.ServeContent(w, req, "data.txt", modified, strings.NewReader("content"))
+	}))
+	defer rec.Close()
+
+	res := rec.ConditionalGetSince("/", modified)
+	res.ExpectCode(http.StatusNotModified)
+	res.ExpectBodyEquals("")
+	if expected := "Wed, 21 Oct 2015 07:28:00 GMT"; ifModifiedSince != expected {
+		t.Errorf("Expected If-Modified-Since to be %q but got %q", expected, ifModifiedSince)
+	}
+
+	// The time is converted to GMT regardless of its location.
+	pacific := time.FixedZone("PDT", -7*60*60)
+	rec.ConditionalGetSince("/", modified.Add(time.Hour).In(pacific)).ExpectCode(http.StatusNotModified)
+	if expected := "Wed, 21 Oct 2015 08:28:00 GMT"; ifModifiedSince != expected {
+		t.Errorf("Expected If-Modified-Since to be %q but got %q", expected, ifModifiedSince)
+	}
+
+	res = rec.ConditionalGetSince("/", modified.Add(-time.Second))
+	res.ExpectOk()
+	res.ExpectBodyEquals("content")
+	if lastModified := res.Header.Get("Last-Modified"); lastModified != "Wed, 21 Oct 2015 07:28:00 GMT" {
+		t.Errorf("Expected Last-Modified to be the modification time but got %q", lastModified)
+	}
+}
+
+func TestReset(t *testing.T) {
+	rec := NewRecorder(t, sessionHandler)
+	defer rec.Close()
+	rec.RegisterService("self", rec.server.URL)
+	rec.Vars["user"] = "alice"
+	rec.SetBearerToken("secret")
+	rec.SetDefaultQueryParam("api_key", "secret")
+	rec.Login("/login", map[string]string{"username": "{{user}}", "password": "secret"})
+	rec.Get("/me").ExpectBodyEquals("alice")
+	service := rec.Service("self")
+
+	rec.Reset()
+	if len(rec.History) != 0 {
+		t.Errorf("Expected History to be empty after Reset but got %d responses", len(rec.History))
+	}
+	if len(rec.Vars) != 0 || len(rec.DefaultHeaders) != 0 || len(rec.DefaultQuery) != 0 {
+		t.Errorf("Expected Vars, DefaultHeaders, and DefaultQuery to be empty after Reset but got %v, %v, %v", rec.Vars, rec.DefaultHeaders, rec.DefaultQuery)
+	}
+
+	// Reset keeps the cookie jar, so the session is remembered.
+	rec.Get("/me").ExpectBodyEquals("alice")
+	if len(rec.History) != 1 {
+		t.Errorf("Expected History to have 1 response after Reset but got %d", len(rec.History))
+	}
+
+	// ResetCookies forgets the session, including for services which share
+	// the cookie jar.
+	rec.ResetCookies()
+	if cookies := rec.GetCookies(); len(cookies) != 0 {
+		t.Errorf("Expected no cookies after ResetCookies but got %v", cookies)
+	}
+	rec.Get("/me").ExpectCode(http.StatusUnauthorized)
+	service.Get("/me").ExpectCode(http.StatusUnauthorized)
+	rec.Login("/login", map[string]string{"username": "alice", "password": "secret"})
+	rec.Get("/me").ExpectBodyEquals("alice")
+	service.Get("/me").ExpectBodyEquals("alice")
+}
+
+func TestServiceOptionsAreCopied(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	headers := NewRecorder(t, echoHeaderHandler("Authorization"))
+	defer headers.Close()
+	rec.RegisterService("self", rec.server.URL)
+	rec.RegisterService("headers", headers.server.URL)
+	rec.SetBearerToken("parent")
+	rec.SetDefaultQueryParam("tenant", "parent")
+	rec.Vars["id"] = 1
+
+	service := rec.Service("self")
+	service.SetBearerToken("service")
+	service.SetDefaultQueryParam("tenant", "service")
+	service.Vars["id"] = 2
+	service.Vars["extra"] = 3
+	service.RegisterService("other", "http://other.invalid")
+	service.HeaderInjectors = append(service.HeaderInjectors, func(ctx context.Context, header http.Header) {
+		header.Set("Authorization", "injected")
+	})
+	service.ForbiddenHeaders = append(service.ForbiddenHeaders, "X-Never-Sent")
+	service.BodyFormatters["text/plain"] = service.BodyFormatters["application/json"]
+
+	service.Get("/{{id}}").ExpectBodyEquals("/2?tenant=service\n")
+	rec.Get("/{{id}}").ExpectBodyEquals("/1?tenant=parent\n")
+	rec.Service("headers").Get("/").ExpectBodyEquals("Bearer parent")
+	if auth := rec.DefaultHeaders.Get("Authorization"); auth != "Bearer parent" {
+		t.Errorf("Expected parent Authorization header to be unchanged but got %q", auth)
+	}
+	if _, found := rec.Vars["extra"]; found {
+		t.Error("Expected var set on the service not to be set on the parent")
+	}
+	if _, found := rec.services["other"]; found {
+		t.Error("Expected service registered on the service not to be registered on the parent")
+	}
+	if len(rec.HeaderInjectors) != 0 || len(rec.ForbiddenHeaders) != 0 {
+		t.Errorf("Expected parent HeaderInjectors and ForbiddenHeaders to be unchanged but got %d and %v", len(rec.HeaderInjectors), rec.ForbiddenHeaders)
+	}
+	if _, found := rec.BodyFormatters["text/plain"]; found {
+		t.Error("Expected body formatter set on the service not to be set on the parent")
+	}
+
+	// Changes to the parent after Service is called do not affect the service.
+	rec.Vars["id"] = 5
+	service.Get("/{{id}}").ExpectBodyEquals("/2?tenant=service\n")
+}
+
+// wireListener is a net.Listener which copies everything read from its
+// connections into wire, so that tests can check the exact bytes sent by the
+// client.
+type wireListener struct {
+	net.Listener
+	mut  sync.Mutex
+	wire []byte
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &wireConn{Conn: conn, listener: l}, nil
+}
+
+// String returns everything read from the connections of l so far.
+func (l *wireListener) String() string {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return string(l.wire)
+}
+
+// wireConn is a connection accepted by a wireListener.
+type wireConn struct {
+	net.Conn
+	listener *wireListener
+}
+
+func (c *wireConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.listener.mut.Lock()
+	c.listener.wire = append(c.listener.wire, b[:n]...)
+	c.listener.mut.Unlock()
+	return n, err
+}
+
+func TestNewMethodOverrideRequest(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			http.Error(w, "method should be POST but got "+req.Method, http.StatusMethodNotAllowed)
+			return
+		}
+		req.ParseForm()
+		fmt.Fprintf(w, "%s %s", req.Header.Get("X-HTTP-Method-Override"), req.PostForm.Get("name"))
+	}))
+	listener := &wireListener{Listener: server.Listener}
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	rec := NewURLRecorder(t, server.URL)
+
+	for _, override := range []string{"PUT", "DELETE", "PATCH"} {
+		req := rec.NewMethodOverrideRequest("POST", override, "/users/1", map[string]string{"name": "alice"})
+		rec.Do(req).ExpectBodyEquals(override + " alice")
+		// The server canonicalizes header names, so the casing can only be
+		// checked on the wire.
+		if expected := "\r\nX-HTTP-Method-Override: " + override + "\r\n"; !strings.Contains(listener.String(), expected) {
+			t.Errorf("Expected request to be sent with header %q but got:\n%s", strings.TrimSpace(expected), listener.String())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// ReplayMode determines how a Recorder uses fixture files. Fixtures make it
+// possible to run tests deterministically and without access to the network.
+type ReplayMode int
+
+const (
+	// ReplayOff means every request is sent over the network and fixtures are
+	// neither read nor written.
+	ReplayOff ReplayMode = iota
+	// ReplayRecord means every request is sent over the network and the
+	// response is saved as a fixture, overwriting any existing fixture.
+	ReplayRecord
+	// ReplayPlayback means every response is served from an existing fixture.
+	// No requests are sent over the network and a missing fixture is passed to
+	// t.Fatal.
+	ReplayPlayback
+	// ReplayAuto means responses are served from existing fixtures when
+	// possible. If there is no fixture for a request, the request is sent over
+	// the network and the response is saved as a fixture.
+	ReplayAuto
+)
+
+// fixture is a response which has been saved to disk.
+type fixture struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+// replay returns the response for req according to r.ReplayMode. Fixtures are
+// identified by the method, url, and body of req. Any errors that occur will
+// be passed to t.Fatal
+func (r *Recorder) replay(req *http.Request) *http.Response {
+	body := r.readRequestBody(req)
+	filename := r.fixturePath(req, body)
+	if r.ReplayMode == ReplayPlayback || r.ReplayMode == ReplayAuto {
+		data, err := ioutil.ReadFile(filename)
+		if err == nil {
+			return r.fixtureResponse(req, data)
+		}
+		if !os.IsNotExist(err) || r.ReplayMode == ReplayPlayback {
+			r.t.Fatal(err)
+		}
+	}
+	httpResp, err := r.client.Do(req)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.saveFixture(filename, req, httpResp)
+	return httpResp
+}
+
+// fixturePath returns the path of the fixture file for a request with the
+// given body.
+func (r *Recorder) fixturePath(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n", req.Method, req.URL.String())
+	hash.Write(body)
+	return filepath.Join(r.FixturesDir, hex.EncodeToString(hash.Sum(nil))+".json")
+}
+
+// saveFixture saves httpResp to the fixture file with the given filename. The
+// body of httpResp is replaced so that it can still be read afterwards. Any
+// errors that occur will be passed to t.Fatal
+func (r *Recorder) saveFixture(filename string, req *http.Request, httpResp *http.Response) {
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	httpResp.Body.Close()
+	httpResp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	data, err := json.MarshalIndent(fixture{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		StatusCode: httpResp.StatusCode,
+		Header:     httpResp.Header,
+		Body:       body,
+	}, "", "\t")
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// fixtureResponse converts the contents of a fixture file into an
+// *http.Response for req. Any cookies set by the response are stored in the
+// cookie jar, just as if the response had been received over the network.
+// Any errors that occur will be passed to t.Fatal
+func (r *Recorder) fixtureResponse(req *http.Request, data []byte) *http.Response {
+	f := fixture{}
+	if err := json.Unmarshal(data, &f); err != nil {
+		r.t.Fatal(err)
+	}
+	httpResp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.StatusCode, http.StatusText(f.StatusCode)),
+		StatusCode:    f.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}
+	if httpResp.Header == nil {
+		httpResp.Header = http.Header{}
+	}
+	r.client.Jar.SetCookies(req.URL, httpResp.Cookies())
+	return httpResp
+}
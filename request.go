@@ -0,0 +1,104 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Request is a declarative description of a request, which can be sent with
+// Recorder.Send. It is especially useful for table-driven tests.
+type Request struct {
+	// Method is the http method of the request. The default is GET.
+	Method string
+	// Path is appended to the baseURL of the recorder to create the full URL.
+	// It may include a query string.
+	Path string
+	// Headers are added to the request.
+	Headers map[string]string
+	// Query holds parameters which are added to the query string of the URL.
+	Query map[string]string
+	// Body is the body of the request. A string or []byte is sent as is. A
+	// map[string]string is encoded as form data
+	// (application/x-www-form-urlencoded). Any other value is encoded as
+	// JSON (application/json). If Body is nil, the request has no body.
+	Body interface{}
+	// ContentType overrides the Content-Type header which would otherwise be
+	// determined by the type of Body.
+	ContentType string
+}
+
+// Send builds the request described by req and sends it, recording the
+// results into a fipple.Response. Any errors that occur will be passed to
+// t.Fatal
+func (r *Recorder) Send(req Request) *Response {
+	return r.Do(r.NewRequestFromStruct(req))
+}
+
+// NewRequestFromStruct builds and returns the request described by req
+// without sending it. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) NewRequestFromStruct(req Request) *http.Request {
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	fullURL, err := url.Parse(r.baseURL + req.Path)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if len(req.Query) > 0 {
+		query := fullURL.Query()
+		for key, value := range req.Query {
+			query.Set(key, value)
+		}
+		fullURL.RawQuery = query.Encode()
+	}
+
+	// Encode the body according to its type
+	var body io.Reader
+	contentType := ""
+	switch data := req.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(data)
+	case []byte:
+		body = bytes.NewReader(data)
+	case map[string]string:
+		v := url.Values{}
+		for key, value := range data {
+			v.Add(key, value)
+		}
+		body = strings.NewReader(v.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	default:
+		buf := bytes.NewBuffer([]byte{})
+		if err := json.NewEncoder(buf).Encode(data); err != nil {
+			r.t.Fatal(err)
+		}
+		body = buf
+		contentType = "application/json"
+	}
+	if req.ContentType != "" {
+		contentType = req.ContentType
+	}
+
+	// Create and return the request object
+	httpReq, err := http.NewRequest(method, fullURL.String(), body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if contentType != "" {
+		httpReq.Header.Set("Content-Type", contentType)
+	}
+	for key, value := range req.Headers {
+		httpReq.Header.Set(key, value)
+	}
+	return httpReq
+}
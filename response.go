@@ -7,10 +7,18 @@ package fipple
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/wsxiaoys/terminal/color"
 )
@@ -19,22 +27,43 @@ import (
 // make testing easier.
 type Response struct {
 	*http.Response
-	Body     []byte
-	recorder *Recorder
-	once     sync.Once
+	// Body is the body of the response. If the Content-Type of the response is
+	// application/json, Body is automatically indented.
+	Body []byte
+	// RawBody is the body of the response exactly as it was received, without
+	// any indentation.
+	RawBody []byte
+	// Panic holds the details of the panic which occurred in the handler while
+	// it was handling the request, if any. It is only set if the
+	// RecoverHandler option of the recorder is true.
+	Panic *HandlerPanic
+	// ServerLogs holds the lines written to the ServerLog of the recorder
+	// while the handler was handling the request. It is only set if the
+	// CaptureServerLogs option of the recorder is true.
+	ServerLogs []string
+	recorder   *Recorder
+	once       sync.Once
+	startTime  time.Time
+	duration   time.Duration
+	wireSize   int64
 }
 
-// readBody reads r.Response.Body into r.Body. If the content-type is json,
-// the body is automatically indented.
+// readBody reads r.Response.Body into r.RawBody and r.Body. If the
+// content-type is json, r.Body is automatically indented.
 func (r *Response) readBody() {
-	buf := bytes.NewBuffer(r.Body)
+	raw, err := ioutil.ReadAll(r.Response.Body)
+	if err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	r.Response.Body.Close()
+	r.RawBody = raw
+	r.Body = raw
 	if strings.Contains(r.Header.Get("Content-Type"), "application/json") {
-		body, _ := ioutil.ReadAll(r.Response.Body)
-		json.Indent(buf, body, "", "\t")
-	} else {
-		buf.ReadFrom(r.Response.Body)
+		buf := bytes.NewBuffer([]byte{})
+		if err := json.Indent(buf, raw, "", "\t"); err == nil {
+			r.Body = buf.Bytes()
+		}
 	}
-	r.Body = buf.Bytes()
 }
 
 // Unmarshal unmarshals the response body into v.
@@ -42,46 +71,730 @@ func (r *Response) Unmarshal(v interface{}) error {
 	return json.Unmarshal(r.Body, v)
 }
 
+// Discard reads any remaining data from the underlying http.Response body and
+// closes it, which allows the connection to be reused for later requests.
+// Responses returned by Do (and the methods built on it) have already been
+// read in full and closed, so calling Discard on them is harmless but
+// unnecessary. It is needed only when the underlying body has been replaced
+// with one that has not been read.
+func (r *Response) Discard() {
+	if r.Response.Body == nil {
+		return
+	}
+	io.Copy(ioutil.Discard, r.Response.Body)
+	r.Response.Body.Close()
+}
+
+// Reader returns an io.Reader over the body of the response exactly as it was
+// received (i.e. RawBody). This is useful for passing the body to functions
+// which expect a reader, such as image.Decode or csv.NewReader. Each call
+// returns a new reader which starts at the beginning of the body.
+func (r *Response) Reader() io.Reader {
+	return bytes.NewReader(r.RawBody)
+}
+
+// subtest calls fn. If r.recorder.SubtestMode is true, fn is run as a subtest
+// with the given name, and any test errors reported by fn belong to the
+// subtest.
+func (r *Response) subtest(name string, fn func()) {
+	rec := r.recorder
+	if !rec.SubtestMode {
+		fn()
+		return
+	}
+	parent := rec.t
+	parent.Run(name, func(t *testing.T) {
+		rec.t = t
+		defer func() {
+			rec.t = parent
+		}()
+		fn()
+	})
+}
+
+// errorf reports a failed assertion via t.Errorf, or via t.Fatalf if the
+// FailFast option of the recorder is true.
+func (r *Response) errorf(format string, args ...interface{}) {
+	r.recorder.errorf(format, args...)
+}
+
+// expect causes a test error with the given message if ok is false. The
+// information printed by PrintFailureOnce is included with the error.
+func (r *Response) expect(ok bool, msg string) {
+	if !ok {
+		r.PrintFailureOnce()
+		r.errorf("%s", msg)
+	}
+}
+
 // ExpectOk causes a test error if response code != 200
 func (r *Response) ExpectOk() {
-	r.ExpectCode(200)
+	r.subtest("ExpectOk", func() {
+		r.expect(r.CheckOk())
+	})
+}
+
+// CheckOk is like ExpectOk but instead of causing a test error, it returns
+// whether or not the response code == 200 and a message describing the
+// failure (or an empty string if there was no failure).
+func (r *Response) CheckOk() (bool, string) {
+	return r.CheckCode(200)
+}
+
+// ExpectOk2xx causes a test error if response code is not in the range
+// 200-299. Unlike ExpectOk, which only accepts 200, ExpectOk2xx also accepts
+// other successful codes such as 201 (Created) and 204 (No Content).
+func (r *Response) ExpectOk2xx() {
+	r.subtest("ExpectOk2xx", func() {
+		r.expect(r.CheckOk2xx())
+	})
+}
+
+// CheckOk2xx is like ExpectOk2xx but returns the outcome and a failure
+// message instead of causing a test error.
+func (r *Response) CheckOk2xx() (bool, string) {
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		return false, fmt.Sprintf("Expected a 2xx response code but got: %d", r.StatusCode)
+	}
+	return true, ""
 }
 
 // ExpectCode causes a test error if response code != the given code
 func (r *Response) ExpectCode(code int) {
+	r.subtest(fmt.Sprintf("ExpectCode(%d)", code), func() {
+		r.expect(r.CheckCode(code))
+	})
+}
+
+// CheckCode is like ExpectCode but returns the outcome and a failure message
+// instead of causing a test error.
+func (r *Response) CheckCode(code int) (bool, string) {
 	if r.StatusCode != code {
-		r.PrintFailureOnce()
-		r.recorder.t.Errorf("Expected response code %d but got: %d", code, r.StatusCode)
+		return false, fmt.Sprintf("Expected response code %d but got: %d", code, r.StatusCode)
 	}
+	return true, ""
 }
 
 // ExpectBodyContains causes a test error if the response body does
 // not contain the given string.
 func (r *Response) ExpectBodyContains(str string) {
+	r.subtest("ExpectBodyContains", func() {
+		r.expect(r.CheckBodyContains(str))
+	})
+}
+
+// CheckBodyContains is like ExpectBodyContains but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckBodyContains(str string) (bool, string) {
 	if !strings.Contains(string(r.Body), str) {
+		return false, fmt.Sprintf("Expected response to contain `%s` but it did not.", str)
+	}
+	return true, ""
+}
+
+// Expect causes a test error if the response code != code or if the response
+// body does not contain bodyContains. Both expectations are checked, and any
+// failures are reported together with the response printed once.
+func (r *Response) Expect(code int, bodyContains string) {
+	r.subtest(fmt.Sprintf("Expect(%d)", code), func() {
+		r.expect(r.Check(code, bodyContains))
+	})
+}
+
+// Check is like Expect but returns the outcome and a failure message instead
+// of causing a test error.
+func (r *Response) Check(code int, bodyContains string) (bool, string) {
+	msgs := []string{}
+	if ok, msg := r.CheckCode(code); !ok {
+		msgs = append(msgs, msg)
+	}
+	if ok, msg := r.CheckBodyContains(bodyContains); !ok {
+		msgs = append(msgs, msg)
+	}
+	return len(msgs) == 0, strings.Join(msgs, "\n")
+}
+
+// ExpectBodyEquals causes a test error if the response body is not exactly
+// equal to the given string. If the Content-Type of the response is
+// application/json, the body is compared after it has been automatically
+// indented. On failure, a line-by-line diff between str and the body is
+// reported instead of the full body.
+func (r *Response) ExpectBodyEquals(str string) {
+	r.subtest("ExpectBodyEquals", func() {
+		if ok, msg := r.CheckBodyEquals(str); !ok {
+			r.errorf("%s request to %s failed. %s",
+				r.Request.Method,
+				r.Request.URL.Path,
+				msg)
+		}
+	})
+}
+
+// CheckBodyEquals is like ExpectBodyEquals but returns the outcome and a
+// failure message (including the diff) instead of causing a test error.
+func (r *Response) CheckBodyEquals(str string) (bool, string) {
+	if body := string(r.Body); body != str {
+		return false, "Expected response body to equal the given string but it did not:\n" + unifiedDiff(str, body)
+	}
+	return true, ""
+}
+
+// ExpectBodyBytesEqual causes a test error if the raw body of the response
+// is not exactly equal to expected, byte for byte. Unlike ExpectBodyEquals,
+// RawBody is used, so it is safe to use for binary data. On failure, the
+// lengths and the offset of the first differing byte are reported.
+func (r *Response) ExpectBodyBytesEqual(expected []byte) {
+	r.subtest("ExpectBodyBytesEqual", func() {
+		r.expect(r.CheckBodyBytesEqual(expected))
+	})
+}
+
+// CheckBodyBytesEqual is like ExpectBodyBytesEqual but returns the outcome and
+// a failure message instead of causing a test error.
+func (r *Response) CheckBodyBytesEqual(expected []byte) (bool, string) {
+	if bytes.Equal(r.RawBody, expected) {
+		return true, ""
+	}
+	offset := 0
+	for offset < len(expected) && offset < len(r.RawBody) && expected[offset] == r.RawBody[offset] {
+		offset++
+	}
+	return false, fmt.Sprintf("Expected response body to equal the given %d bytes but got %d bytes which first differ at offset %d", len(expected), len(r.RawBody), offset)
+}
+
+// ExpectRenderedTemplate causes a test error if the response body is not
+// exactly equal to the output of executing tmpl with the given data. On
+// failure, a line-by-line diff is reported. Any errors that occur while
+// executing the template will be passed to t.Fatal.
+func (r *Response) ExpectRenderedTemplate(tmpl *template.Template, data interface{}) {
+	r.subtest(fmt.Sprintf("ExpectRenderedTemplate(%s)", tmpl.Name()), func() {
+		label := fmt.Sprintf("the rendered template %q", tmpl.Name())
+		r.expectBodyEqualsDiff(label, r.renderTemplate(tmpl, data), string(r.Body))
+	})
+}
+
+// ExpectRenderedTemplateIgnoringWhitespace is like ExpectRenderedTemplate, but
+// differences in whitespace are ignored. Leading and trailing whitespace is
+// removed from each line, runs of whitespace within each line are treated as
+// a single space, and blank lines are removed.
+func (r *Response) ExpectRenderedTemplateIgnoringWhitespace(tmpl *template.Template, data interface{}) {
+	r.subtest(fmt.Sprintf("ExpectRenderedTemplateIgnoringWhitespace(%s)", tmpl.Name()), func() {
+		label := fmt.Sprintf("the rendered template %q (ignoring whitespace)", tmpl.Name())
+		r.expectBodyEqualsDiff(label, normalizeWhitespace(r.renderTemplate(tmpl, data)), normalizeWhitespace(string(r.Body)))
+	})
+}
+
+// renderTemplate executes tmpl with the given data and returns the output.
+// Any errors that occur will be passed to t.Fatal.
+func (r *Response) renderTemplate(tmpl *template.Template, data interface{}) string {
+	buf := bytes.NewBuffer([]byte{})
+	if err := tmpl.Execute(buf, data); err != nil {
+		r.recorder.t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// expectBodyEqualsDiff causes a test error including a line-by-line diff if
+// expected != body. label describes expected in the error message, e.g. "the
+// rendered template \"index\"".
+func (r *Response) expectBodyEqualsDiff(label, expected, body string) {
+	if expected != body {
+		r.errorf("%s request to %s failed. Expected response body to equal %s but it did not:\n%s",
+			r.Request.Method,
+			r.Request.URL.Path,
+			label,
+			unifiedDiff(expected, body))
+	}
+}
+
+// normalizeWhitespace trims each line of s, collapses runs of whitespace
+// within each line into a single space, and removes blank lines.
+func normalizeWhitespace(s string) string {
+	lines := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			lines = append(lines, strings.Join(fields, " "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ExpectContentLength causes a test error if the Content-Length of the
+// response != n.
+func (r *Response) ExpectContentLength(n int64) {
+	r.subtest(fmt.Sprintf("ExpectContentLength(%d)", n), func() {
+		r.expect(r.CheckContentLength(n))
+	})
+}
+
+// CheckContentLength is like ExpectContentLength but returns the outcome and
+// a failure message instead of causing a test error.
+func (r *Response) CheckContentLength(n int64) (bool, string) {
+	if r.ContentLength != n {
+		return false, fmt.Sprintf("Expected Content-Length to be %d but got: %d", n, r.ContentLength)
+	}
+	return true, ""
+}
+
+// ExpectPartialContent causes a test error unless the response is a 206
+// Partial Content response for the bytes from start to end (inclusive) of a
+// resource with the given total length. The Content-Range header must be
+// "bytes start-end/total" and the body must be exactly end-start+1 bytes long.
+// All of the problems are reported in a single error.
+func (r *Response) ExpectPartialContent(start, end, total int64) {
+	r.subtest(fmt.Sprintf("ExpectPartialContent(%d-%d/%d)", start, end, total), func() {
+		r.expect(r.CheckPartialContent(start, end, total))
+	})
+}
+
+// CheckPartialContent is like ExpectPartialContent but returns the outcome
+// and a failure message instead of causing a test error.
+func (r *Response) CheckPartialContent(start, end, total int64) (bool, string) {
+	problems := []string{}
+	if r.StatusCode != http.StatusPartialContent {
+		problems = append(problems, fmt.Sprintf("response code should be %d but got: %d", http.StatusPartialContent, r.StatusCode))
+	}
+	if expected, got := fmt.Sprintf("bytes %d-%d/%d", start, end, total), r.Header.Get("Content-Range"); got != expected {
+		problems = append(problems, fmt.Sprintf("Content-Range should be %q but got: %q", expected, got))
+	}
+	if length := int64(len(r.RawBody)); length != end-start+1 {
+		problems = append(problems, fmt.Sprintf("body should be %d bytes but got: %d", end-start+1, length))
+	}
+	if len(problems) > 0 {
+		return false, "Expected partial content but:\n\t" + strings.Join(problems, "\n\t")
+	}
+	return true, ""
+}
+
+// ExpectContentLengthMatchesBody causes a test error if the Content-Length of
+// the response does not match the actual length of the response body.
+// RawBody is used for the comparison, so the automatic indentation of JSON
+// responses does not affect the result.
+func (r *Response) ExpectContentLengthMatchesBody() {
+	r.subtest("ExpectContentLengthMatchesBody", func() {
+		r.expect(r.CheckContentLengthMatchesBody())
+	})
+}
+
+// CheckContentLengthMatchesBody is like ExpectContentLengthMatchesBody but
+// returns the outcome and a failure message instead of causing a test error.
+func (r *Response) CheckContentLengthMatchesBody() (bool, string) {
+	if r.ContentLength != int64(len(r.RawBody)) {
+		return false, fmt.Sprintf("Expected Content-Length to match body length of %d but got: %d", len(r.RawBody), r.ContentLength)
+	}
+	return true, ""
+}
+
+// ExpectChunked causes a test error if the response was not sent with chunked
+// transfer encoding. Note that the Transfer-Encoding header is removed from
+// r.Header when the response is read, so r.TransferEncoding is checked
+// instead. Also note that the handler can only stream a response if it
+// does not set the Content-Length header, and that small responses which are
+// written all at once may be sent with a Content-Length instead.
+func (r *Response) ExpectChunked() {
+	r.subtest("ExpectChunked", func() {
+		for _, encoding := range r.TransferEncoding {
+			if encoding == "chunked" {
+				return
+			}
+		}
 		r.PrintFailureOnce()
-		r.recorder.t.Errorf("Expected response to contain `%s` but it did not.", str)
+		r.errorf("Expected response to use chunked transfer encoding but got: %v", r.TransferEncoding)
+	})
+}
+
+// ExpectValidUTF8 causes a test error if the response body is not valid
+// UTF-8. The error includes the byte offset of the first invalid sequence.
+func (r *Response) ExpectValidUTF8() {
+	r.subtest("ExpectValidUTF8", func() {
+		r.expect(r.CheckValidUTF8())
+	})
+}
+
+// CheckValidUTF8 is like ExpectValidUTF8 but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckValidUTF8() (bool, string) {
+	for offset := 0; offset < len(r.RawBody); {
+		c, size := utf8.DecodeRune(r.RawBody[offset:])
+		if c == utf8.RuneError && size == 1 {
+			return false, fmt.Sprintf("Expected response body to be valid UTF-8 but found an invalid byte sequence at offset %d.", offset)
+		}
+		offset += size
 	}
+	return true, ""
+}
+
+// ExpectProtoAtLeast causes a test error if the response was received using
+// a version of the HTTP protocol lower than major.minor. For example,
+// ExpectProtoAtLeast(2, 0) checks that HTTP/2 was used. Note that a recorder
+// created with NewRecorder uses a plain (non-TLS) server, so its responses
+// always use HTTP/1.1.
+func (r *Response) ExpectProtoAtLeast(major, minor int) {
+	r.subtest(fmt.Sprintf("ExpectProtoAtLeast(%d.%d)", major, minor), func() {
+		r.expect(r.CheckProtoAtLeast(major, minor))
+	})
+}
+
+// CheckProtoAtLeast is like ExpectProtoAtLeast but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckProtoAtLeast(major, minor int) (bool, string) {
+	if r.ProtoMajor < major || (r.ProtoMajor == major && r.ProtoMinor < minor) {
+		return false, fmt.Sprintf("Expected protocol to be at least HTTP/%d.%d but got: %s", major, minor, r.Proto)
+	}
+	return true, ""
+}
+
+// ExpectRateLimited causes a test error if response code != 429 (Too Many
+// Requests) or if the response does not have a valid Retry-After header. The
+// Retry-After header can be either a number of seconds or an HTTP-date. The
+// amount of time the client was asked to wait is returned, or 0 if the
+// Retry-After header was missing or invalid.
+func (r *Response) ExpectRateLimited() time.Duration {
+	var wait time.Duration
+	r.subtest("ExpectRateLimited", func() {
+		failures := []string{}
+		if r.StatusCode != http.StatusTooManyRequests {
+			failures = append(failures, fmt.Sprintf("Expected response code %d but got: %d", http.StatusTooManyRequests, r.StatusCode))
+		}
+		retryAfter := r.Header.Get("Retry-After")
+		if d, ok := parseRetryAfter(retryAfter, r.recorder.Now()); ok {
+			wait = d
+		} else if retryAfter == "" {
+			failures = append(failures, "Expected response to have a Retry-After header but it did not.")
+		} else {
+			failures = append(failures, fmt.Sprintf("Expected Retry-After header to be a number of seconds or an HTTP-date but got: %q", retryAfter))
+		}
+		r.expect(len(failures) == 0, strings.Join(failures, "\n"))
+	})
+	return wait
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which can be
+// either a number of seconds or an HTTP-date, and returns the amount of time
+// to wait relative to now. The second return value is false if value is not
+// valid.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := date.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// ExpectNoHeader causes a test error if the response has a header with the
+// given name and a non-empty value.
+func (r *Response) ExpectNoHeader(name string) {
+	r.subtest(fmt.Sprintf("ExpectNoHeader(%s)", name), func() {
+		r.expect(r.CheckNoHeader(name))
+	})
+}
+
+// CheckNoHeader is like ExpectNoHeader but returns the outcome and a failure
+// message instead of causing a test error.
+func (r *Response) CheckNoHeader(name string) (bool, string) {
+	if value := r.Header.Get(name); value != "" {
+		return false, fmt.Sprintf("Expected response not to have header %s but got: %q", name, value)
+	}
+	return true, ""
+}
+
+// ExpectCharset causes a test error if the charset parameter of the
+// Content-Type header of the response is not charset. The comparison is
+// case-insensitive, so "UTF-8" matches "utf-8".
+func (r *Response) ExpectCharset(charset string) {
+	r.subtest(fmt.Sprintf("ExpectCharset(%s)", charset), func() {
+		r.expect(r.CheckCharset(charset))
+	})
+}
+
+// CheckCharset is like ExpectCharset but returns the outcome and a failure
+// message instead of causing a test error.
+func (r *Response) CheckCharset(charset string) (bool, string) {
+	contentType := r.Header.Get("Content-Type")
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false, fmt.Sprintf("Expected Content-Type with charset %s but could not parse: %q", charset, contentType)
+	}
+	if got := params["charset"]; !strings.EqualFold(got, charset) {
+		return false, fmt.Sprintf("Expected charset %s but got: %q", charset, got)
+	}
+	return true, ""
+}
+
+// ExpectDeprecated causes a test error if the response does not have a
+// Deprecation header. If the response also has a Sunset header, it must be a
+// valid HTTP-date.
+func (r *Response) ExpectDeprecated() {
+	r.subtest("ExpectDeprecated", func() {
+		r.expect(r.CheckDeprecated())
+	})
+}
+
+// CheckDeprecated is like ExpectDeprecated but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckDeprecated() (bool, string) {
+	if r.Header.Get("Deprecation") == "" {
+		return false, "Expected response to have a Deprecation header but it did not."
+	}
+	if sunset := r.Header.Get("Sunset"); sunset != "" {
+		if _, err := http.ParseTime(sunset); err != nil {
+			return false, fmt.Sprintf("Expected Sunset header to be an HTTP-date but got: %q", sunset)
+		}
+	}
+	return true, ""
+}
+
+// ExpectNotDeprecated causes a test error if the response has a Deprecation
+// or Sunset header. This is useful for catching deprecation markers which
+// were added to active endpoints by mistake.
+func (r *Response) ExpectNotDeprecated() {
+	r.subtest("ExpectNotDeprecated", func() {
+		r.expect(r.CheckNotDeprecated())
+	})
+}
+
+// CheckNotDeprecated is like ExpectNotDeprecated but returns the outcome and
+// a failure message instead of causing a test error.
+func (r *Response) CheckNotDeprecated() (bool, string) {
+	for _, name := range []string{"Deprecation", "Sunset"} {
+		if ok, msg := r.CheckNoHeader(name); !ok {
+			return false, msg
+		}
+	}
+	return true, ""
+}
+
+// ExpectVaryContains causes a test error if field is not listed in the Vary
+// header of the response. The Vary header may be split across multiple lines
+// and fields are compared case-insensitively.
+func (r *Response) ExpectVaryContains(field string) {
+	r.subtest(fmt.Sprintf("ExpectVaryContains(%s)", field), func() {
+		r.expect(r.CheckVaryContains(field))
+	})
+}
+
+// CheckVaryContains is like ExpectVaryContains but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckVaryContains(field string) (bool, string) {
+	for _, value := range r.Header.Values("Vary") {
+		for _, listed := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(listed), field) {
+				return true, ""
+			}
+		}
+	}
+	return false, fmt.Sprintf("Expected Vary header to contain %s but got: %q", field, strings.Join(r.Header.Values("Vary"), ", "))
+}
+
+// ExpectNoDebugError causes a test error if the response has a non-empty
+// header with the name given by the DebugErrorHeader option of the recorder
+// (X-Debug-Error by default). This can be used to catch errors which occurred
+// on the server but did not cause an error response code.
+func (r *Response) ExpectNoDebugError() {
+	r.ExpectNoHeader(r.recorder.DebugErrorHeader)
+}
+
+// ExpectTrailer causes a test error if the response does not have a trailer
+// with the given name and value. Trailers are sent after the body, so they are
+// only available once the body has been read. This is always the case for
+// responses returned by the recorder.
+func (r *Response) ExpectTrailer(name, value string) {
+	r.subtest(fmt.Sprintf("ExpectTrailer(%s)", name), func() {
+		r.expect(r.CheckTrailer(name, value))
+	})
+}
+
+// CheckTrailer is like ExpectTrailer but returns the outcome and a failure
+// message instead of causing a test error.
+func (r *Response) CheckTrailer(name, value string) (bool, string) {
+	if _, found := r.Trailer[http.CanonicalHeaderKey(name)]; !found {
+		return false, fmt.Sprintf("Expected response to have trailer %s but it did not.", name)
+	}
+	if actual := r.Trailer.Get(name); actual != value {
+		return false, fmt.Sprintf("Expected trailer %s to be %q but got: %q", name, value, actual)
+	}
+	return true, ""
+}
+
+// ExpectAttachment causes a test error if the Content-Disposition header of
+// the response does not indicate an attachment (i.e. a download) with the
+// given filename.
+func (r *Response) ExpectAttachment(filename string) {
+	r.subtest(fmt.Sprintf("ExpectAttachment(%s)", filename), func() {
+		r.expect(r.CheckAttachment(filename))
+	})
+}
+
+// CheckAttachment is like ExpectAttachment but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckAttachment(filename string) (bool, string) {
+	header := r.Header.Get("Content-Disposition")
+	disposition, params, err := mime.ParseMediaType(header)
+	if err != nil || disposition != "attachment" || params["filename"] != filename {
+		return false, fmt.Sprintf("Expected Content-Disposition to be an attachment with filename %q but got: %q", filename, header)
+	}
+	return true, ""
+}
+
+// ExpectCookieCount causes a test error if the response does not set exactly
+// n cookies (i.e. have n Set-Cookie headers).
+func (r *Response) ExpectCookieCount(n int) {
+	r.subtest(fmt.Sprintf("ExpectCookieCount(%d)", n), func() {
+		r.expect(r.CheckCookieCount(n))
+	})
+}
+
+// CheckCookieCount is like ExpectCookieCount but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckCookieCount(n int) (bool, string) {
+	if cookies := r.Cookies(); len(cookies) != n {
+		return false, fmt.Sprintf("Expected response to set %d cookies but it set %d: %v", n, len(cookies), cookieNames(cookies))
+	}
+	return true, ""
+}
+
+// ExpectNoCookies causes a test error if the response has any Set-Cookie
+// headers. The names of the cookies are reported on failure. This is useful
+// for endpoints which must remain stateless, e.g. for caching or privacy.
+func (r *Response) ExpectNoCookies() {
+	r.subtest("ExpectNoCookies", func() {
+		r.expect(r.CheckNoCookies())
+	})
+}
+
+// CheckNoCookies is like ExpectNoCookies but returns the outcome and a
+// failure message instead of causing a test error.
+func (r *Response) CheckNoCookies() (bool, string) {
+	if headers := r.Header.Values("Set-Cookie"); len(headers) > 0 {
+		return false, fmt.Sprintf("Expected response not to set any cookies but it had %d Set-Cookie headers: %v", len(headers), cookieNames(r.Cookies()))
+	}
+	return true, ""
+}
+
+// cookieNames returns the names of the given cookies.
+func cookieNames(cookies []*http.Cookie) []string {
+	names := []string{}
+	for _, cookie := range cookies {
+		names = append(names, cookie.Name)
+	}
+	return names
+}
+
+// ExpectNotHTML causes a test error if the response looks like an HTML page,
+// i.e. the Content-Type is text/html or the body starts with <!DOCTYPE or
+// <html. This is useful for catching error pages returned by a reverse proxy
+// or other infrastructure in place of an API response.
+func (r *Response) ExpectNotHTML() {
+	r.subtest("ExpectNotHTML", func() {
+		r.expect(r.CheckNotHTML())
+	})
+}
+
+// CheckNotHTML is like ExpectNotHTML but returns the outcome and a failure
+// message instead of causing a test error.
+func (r *Response) CheckNotHTML() (bool, string) {
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil && mediaType == "text/html" {
+		return false, fmt.Sprintf("Expected response not to be HTML but Content-Type was %q", contentType)
+	}
+	start := bytes.TrimSpace(r.RawBody)
+	if len(start) > len("<!doctype") {
+		start = start[:len("<!doctype")]
+	}
+	if lower := strings.ToLower(string(start)); strings.HasPrefix(lower, "<!doctype") || strings.HasPrefix(lower, "<html") {
+		return false, "Expected response not to be HTML but the body is an HTML document."
+	}
+	return true, ""
+}
+
+// headerInt parses the value of the header with the given name as an integer.
+// If the header is missing or cannot be parsed, a test error is reported and
+// the second return value is false.
+func (r *Response) headerInt(name string) (int, bool) {
+	value := r.Header.Get(name)
+	if value == "" {
+		r.PrintFailureOnce()
+		r.errorf("Expected header %s to be an integer but it was missing.", name)
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		r.PrintFailureOnce()
+		r.errorf("Expected header %s to be an integer but got: %q", name, value)
+		return 0, false
+	}
+	return n, true
+}
+
+// ExpectHeaderInt causes a test error if the header with the given name is
+// not an integer equal to expected.
+func (r *Response) ExpectHeaderInt(name string, expected int) {
+	r.subtest(fmt.Sprintf("ExpectHeaderInt(%s)", name), func() {
+		if n, ok := r.headerInt(name); ok && n != expected {
+			r.PrintFailureOnce()
+			r.errorf("Expected header %s to be %d but got: %d", name, expected, n)
+		}
+	})
+}
+
+// ExpectHeaderIntLessThan causes a test error if the header with the given
+// name is not an integer less than max.
+func (r *Response) ExpectHeaderIntLessThan(name string, max int) {
+	r.subtest(fmt.Sprintf("ExpectHeaderIntLessThan(%s)", name), func() {
+		if n, ok := r.headerInt(name); ok && n >= max {
+			r.PrintFailureOnce()
+			r.errorf("Expected header %s to be less than %d but got: %d", name, max, n)
+		}
+	})
+}
+
+// ExpectHeaderIntGreaterThan causes a test error if the header with the given
+// name is not an integer greater than min.
+func (r *Response) ExpectHeaderIntGreaterThan(name string, min int) {
+	r.subtest(fmt.Sprintf("ExpectHeaderIntGreaterThan(%s)", name), func() {
+		if n, ok := r.headerInt(name); ok && n <= min {
+			r.PrintFailureOnce()
+			r.errorf("Expected header %s to be greater than %d but got: %d", name, min, n)
+		}
+	})
 }
 
 // PrintFailure prints some information about the response via t.Errorf. This
 // includes the method, the url, and the response body. If the Content-Type of
 // the response is application/json, PrintFailure will automatically indent it.
+// If the request had a body, it is also printed. Bodies with a Content-Type
+// listed in the BodyFormatters option of the recorder are converted into a
+// more readable form first.
 func (r *Response) PrintFailure() {
-	body := string(r.Body)
-	if body == "" {
-		r.recorder.t.Errorf("%s request to %s failed. Response was empty.",
+	var msg string
+	if body := r.formattedBody(); body == "" {
+		msg = fmt.Sprintf("%s request to %s failed. Response was empty.",
 			r.Request.Method,
 			r.Request.URL.Path)
 	} else {
-		if r.recorder.Colorize {
-			body = r.colorBody()
-		}
-		r.recorder.t.Errorf("%s request to %s failed. Response was: \n%s",
+		msg = fmt.Sprintf("%s request to %s failed. Response was: \n%s",
 			r.Request.Method,
 			r.Request.URL.Path,
-			body)
+			r.colorize(body))
+	}
+	if reqBody := r.formattedRequestBody(); reqBody != "" {
+		msg += "\nRequest body was: \n" + r.colorize(reqBody)
 	}
+	r.recorder.t.Error(msg)
+}
+
+// logBody logs the status and body of the response via t.Log.
+func (r *Response) logBody() {
+	r.recorder.t.Logf("%s request to %s responded with %d. Response was: \n%s",
+		r.Request.Method,
+		r.Request.URL.Path,
+		r.StatusCode,
+		r.colorize(r.formattedBody()))
 }
 
 // PrintFailureOnce is like PrintFailure but only prints out the information
@@ -90,8 +803,11 @@ func (r *Response) PrintFailureOnce() {
 	r.once.Do(r.PrintFailure)
 }
 
-// colorBody returns a colorized version of the response body.
-// By default the color is dark grey-ish.
-func (r *Response) colorBody() string {
-	return color.Sprintf("@{.}%s", string(r.Body))
+// colorize returns a colorized version of body if the Colorize option of the
+// recorder is true. By default the color is dark grey-ish.
+func (r *Response) colorize(body string) string {
+	if !r.recorder.Colorize {
+		return body
+	}
+	return color.Sprintf("@{.}%s", body)
 }
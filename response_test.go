@@ -0,0 +1,276 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trailerHandler declares the X-Checksum and X-Declared trailers but only sets
+// X-Checksum, after writing the body.
+var trailerHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Trailer", "X-Checksum, X-Declared")
+	w.Write([]byte("body"))
+	w.(http.Flusher).Flush()
+	w.Header().Set("X-Checksum", "abc123")
+})
+
+func TestExpectTrailer(t *testing.T) {
+	rec := NewRecorder(t, trailerHandler)
+	defer rec.Close()
+	res := rec.Get("/")
+	res.ExpectBodyEquals("body")
+	res.ExpectTrailer("X-Checksum", "abc123")
+	res.ExpectTrailer("x-checksum", "abc123")
+
+	testCases := []struct {
+		name     string
+		value    string
+		expected string
+	}{
+		{"X-Missing", "", "Expected response to have trailer X-Missing but it did not."},
+		{"X-Checksum", "wrong", `Expected trailer X-Checksum to be "wrong" but got: "abc123"`},
+		{"X-Declared", "value", `Expected trailer X-Declared to be "value" but got: ""`},
+	}
+	for _, tc := range testCases {
+		ok, msg := res.CheckTrailer(tc.name, tc.value)
+		if ok {
+			t.Errorf("Expected CheckTrailer(%s, %s) to fail", tc.name, tc.value)
+		} else if msg != tc.expected {
+			t.Errorf("Expected CheckTrailer(%s, %s) to fail with %q but got %q", tc.name, tc.value, tc.expected, msg)
+		}
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			res.ExpectTrailer(tc.name, tc.value)
+		}) {
+			t.Errorf("Expected ExpectTrailer(%s, %s) to fail the test", tc.name, tc.value)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	testCases := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"Wed, 21 Oct 2015 07:30:00 GMT", 2 * time.Minute, true},
+		{"Wednesday, 21-Oct-15 07:28:30 GMT", 30 * time.Second, true},
+		{"Wed Oct 21 07:29:00 2015", time.Minute, true},
+		// A date in the past means there is no need to wait.
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0, true},
+		{"", 0, false},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"soon", 0, false},
+		{"2015-10-21T07:30:00Z", 0, false},
+	}
+	for _, tc := range testCases {
+		wait, ok := parseRetryAfter(tc.value, now)
+		if ok != tc.ok || wait != tc.expected {
+			t.Errorf("Expected parseRetryAfter(%q) to return (%s, %t) but got (%s, %t)", tc.value, tc.expected, tc.ok, wait, ok)
+		}
+	}
+}
+
+func TestExpectRateLimited(t *testing.T) {
+	now := time.Date(2015, time.October, 21, 7, 28, 0, 0, time.UTC)
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if retryAfter := req.URL.Query().Get("retry"); retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		if req.URL.Query().Get("ok") == "" {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer rec.Close()
+	rec.Now = func() time.Time { return now }
+
+	if wait := rec.Get("/?retry=30").ExpectRateLimited(); wait != 30*time.Second {
+		t.Errorf("Expected wait to be 30s but got %s", wait)
+	}
+	res := rec.GetWith("/", func(req *http.Request) {
+		q := req.URL.Query()
+		q.Set("retry", "Wed, 21 Oct 2015 07:29:00 GMT")
+		req.URL.RawQuery = q.Encode()
+	})
+	if wait := res.ExpectRateLimited(); wait != time.Minute {
+		t.Errorf("Expected wait to be 1m but got %s", wait)
+	}
+
+	for _, path := range []string{"/", "/?retry=soon", "/?retry=30&ok=1"} {
+		res := rec.Get(path)
+		var wait time.Duration
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			wait = res.ExpectRateLimited()
+		}) {
+			t.Errorf("Expected ExpectRateLimited to fail for %s", path)
+		}
+		rec.t = t
+		if path == "/?retry=30&ok=1" && wait != 30*time.Second {
+			t.Errorf("Expected wait to be 30s for %s but got %s", path, wait)
+		}
+	}
+}
+
+// doTraced sends a GET request to / with rec and returns true iff it was sent
+// over a reused connection.
+func doTraced(rec *Recorder) bool {
+	reused := false
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			reused = info.Reused
+		},
+	})
+	rec.DoWithContext(ctx, rec.NewRequest("GET", "/"))
+	return reused
+}
+
+func TestDiscardConnectionReuse(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler(strings.Repeat("x", 64<<10)))
+	defer rec.Close()
+
+	// Responses returned by the recorder have already been read, so their
+	// connections are reused.
+	rec.Get("/")
+	if !doTraced(rec) {
+		t.Error("Expected connection to be reused after a recorded response")
+	}
+	rec.Get("/").Discard()
+	if !doTraced(rec) {
+		t.Error("Expected connection to be reused after Discard")
+	}
+
+	// A response whose body has not been read holds on to its connection
+	// until Discard is called.
+	unread := func() *Response {
+		httpResp, err := rec.client.Do(rec.NewRequest("GET", "/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec.newResponse(httpResp)
+	}
+	rec.transport.CloseIdleConnections()
+	res := unread()
+	if doTraced(rec) {
+		t.Error("Expected a new connection while the body of a response is unread")
+	}
+	res.Discard()
+	rec.transport.CloseIdleConnections()
+	unread().Discard()
+	if !doTraced(rec) {
+		t.Error("Expected connection to be reused after Discard")
+	}
+}
+
+func TestExpectPartialContent(t *testing.T) {
+	content := "0123456789"
+	rec := NewRecorder(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/short":
+			// The Content-Range is right but the body is one byte short.
+			w.Header().Set("Content-Range", "bytes 2-5/10")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("234"))
+		case "/total":
+			w.Header().Set("Content-Range", "bytes 2-5/*")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("2345"))
+		default:
+			http.ServeContent(w, req, "digits.txt", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer rec.Close()
+
+	rec.GetRange("/", 2, 5).ExpectPartialContent(2, 5, 10)
+	rec.GetRange("/", 9, 9).ExpectPartialContent(9, 9, 10)
+
+	testCases := []struct {
+		res        *Response
+		start, end int64
+		total      int64
+		expected   string
+	}{
+		{rec.GetRange("/short", 2, 5), 2, 5, 10, "Expected partial content but:\n\tbody should be 4 bytes but got: 3"},
+		{rec.GetRange("/total", 2, 5), 2, 5, 10, "Expected partial content but:\n\tContent-Range should be \"bytes 2-5/10\" but got: \"bytes 2-5/*\""},
+		{rec.GetRange("/", 2, 5), 2, 5, 11, "Expected partial content but:\n\tContent-Range should be \"bytes 2-5/11\" but got: \"bytes 2-5/10\""},
+		{rec.Get("/"), 0, 9, 10, "Expected partial content but:\n\tresponse code should be 206 but got: 200\n\tContent-Range should be \"bytes 0-9/10\" but got: \"\""},
+	}
+	for _, tc := range testCases {
+		ok, msg := tc.res.CheckPartialContent(tc.start, tc.end, tc.total)
+		if ok {
+			t.Errorf("Expected CheckPartialContent(%d, %d, %d) to fail for %s", tc.start, tc.end, tc.total, tc.res.Request.URL.Path)
+		} else if msg != tc.expected {
+			t.Errorf("Expected CheckPartialContent(%d, %d, %d) to fail with %q but got %q", tc.start, tc.end, tc.total, tc.expected, msg)
+		}
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			tc.res.ExpectPartialContent(tc.start, tc.end, tc.total)
+		}) {
+			t.Errorf("Expected ExpectPartialContent(%d, %d, %d) to fail the test", tc.start, tc.end, tc.total)
+		}
+	}
+}
+
+// greetingTemplate renders a greeting for a name.
+var greetingTemplate = template.Must(template.New("greeting").Parse("<p>\n  Hello, {{.}}!\n</p>\n"))
+
+func TestExpectRenderedTemplate(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler("<p>\n  Hello, alice!\n</p>\n"))
+	defer rec.Close()
+	res := rec.Get("/")
+	res.ExpectRenderedTemplate(greetingTemplate, "alice")
+
+	output, failed := failureOutput(t, func(t *testing.T) {
+		rec.t = t
+		res.ExpectRenderedTemplate(greetingTemplate, "bob")
+	})
+	if !failed {
+		t.Fatalf("Expected ExpectRenderedTemplate to fail but got:\n%s", output)
+	}
+	for _, expected := range []string{
+		`GET request to / failed. Expected response body to equal the rendered template "greeting" but it did not:`,
+		"-  Hello, bob!",
+		"+  Hello, alice!",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q but got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestExpectRenderedTemplateIgnoringWhitespace(t *testing.T) {
+	rec := NewRecorder(t, bodyHandler("<p>\n\n Hello,   alice!  \n</p>"))
+	defer rec.Close()
+	res := rec.Get("/")
+	res.ExpectRenderedTemplateIgnoringWhitespace(greetingTemplate, "alice")
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		res.ExpectRenderedTemplate(greetingTemplate, "alice")
+	}) {
+		t.Error("Expected ExpectRenderedTemplate to fail when whitespace differs")
+	}
+
+	output, failed := failureOutput(t, func(t *testing.T) {
+		rec.t = t
+		res.ExpectRenderedTemplateIgnoringWhitespace(greetingTemplate, "bob")
+	})
+	if !failed {
+		t.Fatalf("Expected ExpectRenderedTemplateIgnoringWhitespace to fail but got:\n%s", output)
+	}
+	if expected := `Expected response body to equal the rendered template "greeting" (ignoring whitespace) but it did not:`; !strings.Contains(output, expected) {
+		t.Errorf("Expected output to contain %q but got:\n%s", expected, output)
+	}
+}
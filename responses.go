@@ -0,0 +1,74 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"encoding/json"
+)
+
+// Responses is a sequence of responses, such as the History of a recorder,
+// which can be checked as a whole.
+type Responses []*Response
+
+// JSONField returns the JSON value at the given dot-separated path in the
+// body of each of the responses, in order. Numbers are returned as
+// json.Number so that no precision is lost. If there is no value at path in
+// one of the responses, the corresponding value is nil. Any errors that occur
+// while decoding the bodies will be passed to t.Fatal.
+func (rs Responses) JSONField(path string) []interface{} {
+	values := make([]interface{}, len(rs))
+	for i, resp := range rs {
+		values[i], _ = lookupJSONPath(resp.decodeJSONNumbers(), path)
+	}
+	return values
+}
+
+// ExpectMonotonicIncreasing causes a test error if the JSON values at the
+// given dot-separated path in the body of each of the responses are not
+// strictly increasing, e.g. because an id generator handed out the same id
+// twice. The values must either all be numbers, which are compared exactly,
+// or all be strings, which are compared lexicographically. The first response
+// whose value is missing, has the wrong type, or is not greater than the
+// value of the previous response is reported. Any errors that occur while
+// decoding the bodies will be passed to t.Fatal.
+func (rs Responses) ExpectMonotonicIncreasing(path string) {
+	if len(rs) == 0 {
+		return
+	}
+	values := rs.JSONField(path)
+	for i := 1; i < len(values); i++ {
+		prev, cur := values[i-1], values[i]
+		if ok, why := jsonLess(prev, cur); !ok {
+			resp := rs[i]
+			resp.subtest("ExpectMonotonicIncreasing", func() {
+				resp.PrintFailureOnce()
+				resp.errorf("Expected JSON field %s to be increasing but response %d had %s after %s%s", jsonPathName(path), i, jsonString(cur), jsonString(prev), why)
+			})
+			return
+		}
+	}
+}
+
+// jsonLess returns true iff a and b are both numbers or both strings and
+// a < b. If they are not comparable, the second return value explains why.
+func jsonLess(a, b interface{}) (bool, string) {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			break
+		}
+		x, xOk := numberRat(a)
+		y, yOk := numberRat(b)
+		return xOk && yOk && x.Cmp(y) < 0, ""
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			break
+		}
+		return a < b, ""
+	}
+	return false, " (values must all be numbers or all be strings)"
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// RetryOptions determines when and how often DoWithBackoff retries a request.
+type RetryOptions struct {
+	// Codes are the response codes which cause the request to be retried,
+	// e.g. 429 and 503.
+	Codes []int
+	// MaxAttempts is the maximum number of times the request is sent,
+	// including the first attempt. Values less than 1 are treated as 1.
+	MaxAttempts int
+	// BaseDelay is the time to wait before the first retry. The delay doubles
+	// after each retry.
+	BaseDelay time.Duration
+	// HonorRetryAfter causes the Retry-After header of a response (if
+	// present) to be used as the delay instead of BaseDelay.
+	HonorRetryAfter bool
+}
+
+// retryable returns true iff a response with the given code should be
+// retried.
+func (opts RetryOptions) retryable(code int) bool {
+	for _, c := range opts.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// DoWithBackoff is like Do, but if the response code is one of opts.Codes, the
+// request is sent again after an exponentially increasing delay, up to
+// opts.MaxAttempts times. The body of req is buffered so that it can be sent
+// again. Only the last response is recorded and returned. Any errors that
+// occur will be passed to t.Fatal
+func (r *Recorder) DoWithBackoff(req *http.Request, opts RetryOptions) *Response {
+	r.prepareRequest(req)
+	body := r.readRequestBody(req)
+	delay := opts.BaseDelay
+	for attempt := 1; ; attempt++ {
+		if req.Body != nil {
+			setRequestBody(req, body)
+		}
+		start := r.Now()
+		httpResp := r.send(req)
+		if attempt >= opts.MaxAttempts || !opts.retryable(httpResp.StatusCode) {
+			return r.record(httpResp, start)
+		}
+		wait := delay
+		if opts.HonorRetryAfter {
+			if d, ok := parseRetryAfter(httpResp.Header.Get("Retry-After"), r.Now()); ok {
+				wait = d
+			}
+		}
+
+		// Discard the response so the connection can be reused
+		io.Copy(ioutil.Discard, httpResp.Body)
+		httpResp.Body.Close()
+
+		time.Sleep(wait)
+		delay *= 2
+	}
+}
+
+// ExpectSuccessRate sends req n times and causes a test error if the fraction
+// of responses with a 2xx code is less than minRate (e.g. 0.99). The body of
+// req is buffered so that it can be sent again. Every response is recorded,
+// but only the success rate is checked. Any errors that occur will be passed
+// to t.Fatal
+func (r *Recorder) ExpectSuccessRate(req *http.Request, n int, minRate float64) {
+	if n < 1 {
+		r.t.Fatalf("Expected ExpectSuccessRate to send at least 1 request but n was %d", n)
+	}
+	r.prepareRequest(req)
+	body := r.readRequestBody(req)
+	successes := 0
+	for i := 0; i < n; i++ {
+		if req.Body != nil {
+			setRequestBody(req, body)
+		}
+		start := r.Now()
+		resp := r.record(r.send(req), start)
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			successes++
+		}
+	}
+	if rate := float64(successes) / float64(n); rate < minRate {
+		r.errorf("Expected at least %.2f%% of %s requests to %s to succeed but only %d of %d (%.2f%%) did",
+			minRate*100,
+			req.Method,
+			req.URL.Path,
+			successes,
+			n,
+			rate*100)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyHandler responds with code and a Retry-After of 0 to the first failures
+// requests and with 200 to the rest. It appends the body of every request to
+// bodies. The body of each response is the number of the attempt.
+func flakyHandler(failures int, code int, bodies *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		*bodies = append(*bodies, string(body))
+		if len(*bodies) <= failures {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(code)
+		}
+		w.Write([]byte(strconv.Itoa(len(*bodies))))
+	})
+}
+
+func TestDoWithBackoff(t *testing.T) {
+	for _, code := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		var bodies []string
+		rec := NewRecorder(t, flakyHandler(2, code, &bodies))
+		opts := RetryOptions{
+			Codes:           []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			MaxAttempts:     5,
+			BaseDelay:       time.Millisecond,
+			HonorRetryAfter: true,
+		}
+		// A body without GetBody can only be sent again if DoWithBackoff
+		// buffers it.
+		req := rec.NewRequest("POST", "/")
+		req.Body = ioutil.NopCloser(strings.NewReader("payload"))
+		res := rec.DoWithBackoff(req, opts)
+		res.ExpectOk()
+		res.ExpectBodyEquals("3")
+		if len(bodies) != 3 {
+			t.Errorf("Expected 3 attempts for code %d but got %d", code, len(bodies))
+		}
+		for i, body := range bodies {
+			if body != "payload" {
+				t.Errorf("Expected body of attempt %d to be %q but got %q", i+1, "payload", body)
+			}
+		}
+		if len(rec.History) != 1 || rec.History[0] != res {
+			t.Errorf("Expected History to hold only the last response but got %d responses", len(rec.History))
+		}
+		rec.Close()
+	}
+}
+
+func TestDoWithBackoffCodes(t *testing.T) {
+	var bodies []string
+	rec := NewRecorder(t, flakyHandler(2, http.StatusTooManyRequests, &bodies))
+	defer rec.Close()
+	opts := RetryOptions{
+		Codes:       []int{http.StatusServiceUnavailable},
+		MaxAttempts: 5,
+		BaseDelay:   time.Millisecond,
+	}
+	res := rec.DoWithBackoff(rec.NewRequest("GET", "/"), opts)
+	res.ExpectCode(http.StatusTooManyRequests)
+	if len(bodies) != 1 {
+		t.Errorf("Expected a code which is not in Codes not to be retried but got %d attempts", len(bodies))
+	}
+}
+
+func TestDoWithBackoffMaxAttempts(t *testing.T) {
+	testCases := []struct {
+		maxAttempts int
+		expected    int
+	}{
+		{3, 3},
+		{1, 1},
+		{0, 1},
+	}
+	for _, tc := range testCases {
+		var bodies []string
+		rec := NewRecorder(t, flakyHandler(10, http.StatusServiceUnavailable, &bodies))
+		opts := RetryOptions{
+			Codes:       []int{http.StatusServiceUnavailable},
+			MaxAttempts: tc.maxAttempts,
+			BaseDelay:   time.Millisecond,
+		}
+		res := rec.DoWithBackoff(rec.NewRequestWithBody("POST", "/", "text/plain", []byte("payload")), opts)
+		res.ExpectCode(http.StatusServiceUnavailable)
+		res.ExpectBodyEquals(strconv.Itoa(tc.expected))
+		if len(bodies) != tc.expected {
+			t.Errorf("Expected %d attempts with MaxAttempts %d but got %d", tc.expected, tc.maxAttempts, len(bodies))
+		}
+		if len(rec.History) != 1 || rec.History[0] != res {
+			t.Errorf("Expected History to hold only the last response with MaxAttempts %d but got %d responses", tc.maxAttempts, len(rec.History))
+		}
+		rec.Close()
+	}
+}
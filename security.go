@@ -0,0 +1,88 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SecurityHeader is a header which is checked by
+// Response.ExpectSecurityHeaders.
+type SecurityHeader struct {
+	// Name is the name of the header.
+	Name string
+	// Valid returns true iff value is an acceptable value for the header. If
+	// Valid is nil, any non-empty value is acceptable.
+	Valid func(value string) bool
+	// Description describes the acceptable values, for use in error messages.
+	Description string
+}
+
+// DefaultSecurityHeaders are the security headers checked by
+// ExpectSecurityHeaders unless the SecurityHeaders option of the recorder is
+// changed.
+var DefaultSecurityHeaders = []SecurityHeader{
+	{
+		Name:        "X-Content-Type-Options",
+		Valid:       func(value string) bool { return strings.EqualFold(value, "nosniff") },
+		Description: "nosniff",
+	},
+	{
+		Name: "X-Frame-Options",
+		Valid: func(value string) bool {
+			return strings.EqualFold(value, "DENY") || strings.EqualFold(value, "SAMEORIGIN")
+		},
+		Description: "DENY or SAMEORIGIN",
+	},
+	{
+		Name:        "Strict-Transport-Security",
+		Valid:       validStrictTransportSecurity,
+		Description: "a positive max-age",
+	},
+}
+
+// validStrictTransportSecurity returns true iff value is a
+// Strict-Transport-Security header with a positive max-age directive.
+func validStrictTransportSecurity(value string) bool {
+	for _, directive := range strings.Split(value, ";") {
+		directive = strings.TrimSpace(directive)
+		if len(directive) > len("max-age=") && strings.EqualFold(directive[:len("max-age=")], "max-age=") {
+			maxAge, err := strconv.Atoi(strings.Trim(directive[len("max-age="):], `"`))
+			return err == nil && maxAge > 0
+		}
+	}
+	return false
+}
+
+// ExpectSecurityHeaders causes a test error if any of the headers in the
+// SecurityHeaders option of the recorder (DefaultSecurityHeaders by default)
+// are missing or have an unacceptable value. All of the problems are
+// reported in a single error.
+func (r *Response) ExpectSecurityHeaders() {
+	r.subtest("ExpectSecurityHeaders", func() {
+		r.expect(r.CheckSecurityHeaders())
+	})
+}
+
+// CheckSecurityHeaders is like ExpectSecurityHeaders but returns the outcome
+// and a failure message instead of causing a test error.
+func (r *Response) CheckSecurityHeaders() (bool, string) {
+	problems := []string{}
+	for _, header := range r.recorder.SecurityHeaders {
+		value := r.Header.Get(header.Name)
+		switch {
+		case value == "":
+			problems = append(problems, fmt.Sprintf("%s is missing", header.Name))
+		case header.Valid != nil && !header.Valid(value):
+			problems = append(problems, fmt.Sprintf("%s should be %s but got: %q", header.Name, header.Description, value))
+		}
+	}
+	if len(problems) > 0 {
+		return false, "Expected response to have security headers but:\n\t" + strings.Join(problems, "\n\t")
+	}
+	return true, ""
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+)
+
+// SSE sends req and parses the body of the response as a stream of
+// Server-Sent Events. onEvent is called with the type and data of each event
+// as soon as it is received, until onEvent returns false or the stream ends.
+// The type of an event is "message" unless it has an event field, and the
+// data of an event with several data fields is joined with newlines, as
+// described by the Server-Sent Events specification. Comments and the id and
+// retry fields are ignored. If req does not have an Accept header, it is set
+// to text/event-stream. A test error is reported if the response code is not
+// 200, in which case onEvent is never called. Unlike Do, the response is not
+// recorded in History. Any errors that occur will be passed to t.Fatal
+func (r *Recorder) SSE(req *http.Request, onEvent func(event, data string) bool) {
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	r.prepareRequest(req)
+	httpResp, err := r.client.Do(req)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		r.errorf("Expected %s request to %s to have response code %d but got: %d",
+			req.Method,
+			req.URL.Path,
+			http.StatusOK,
+			httpResp.StatusCode)
+		return
+	}
+
+	scanner := bufio.NewScanner(httpResp.Body)
+	event, data := "", []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			// A blank line dispatches the event, if it has any data
+			if len(data) > 0 {
+				if event == "" {
+					event = "message"
+				}
+				if !onEvent(event, strings.Join(data, "\n")) {
+					return
+				}
+			}
+			event, data = "", []string{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value := line, ""
+		if colon := strings.Index(line, ":"); colon != -1 {
+			field, value = line[:colon], strings.TrimPrefix(line[colon+1:], " ")
+		}
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		r.t.Fatal(err)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// NewTLSRecorder is like NewRecorder, but the handler is served over HTTPS
+// using a self-signed certificate which the recorder trusts. config, if not
+// nil, is used as the TLS configuration of the server, e.g. to require and
+// verify client certificates by setting ClientAuth and ClientCAs. The recorder
+// will report any errors using t.Error or t.Fatal.
+func NewTLSRecorder(t *testing.T, handler http.Handler, config *tls.Config) *Recorder {
+	r := newRecorder(t, "")
+	r.server = httptest.NewUnstartedServer(r.wrapHandler(handler))
+	if config != nil {
+		r.server.TLS = config.Clone()
+	}
+	r.server.StartTLS()
+	r.baseURL = r.server.URL
+	r.transport.TLSClientConfig = r.server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	return r
+}
+
+// tlsConfig returns the TLS configuration of the transport used by the
+// recorder, creating it if necessary.
+func (r *Recorder) tlsConfig() *tls.Config {
+	if r.transport.TLSClientConfig == nil {
+		r.transport.TLSClientConfig = &tls.Config{}
+	}
+	return r.transport.TLSClientConfig
+}
+
+// SetClientCertificate causes the recorder to present cert as its client
+// certificate when a server requests one during the TLS handshake. This makes
+// it possible to test endpoints protected by mutual TLS.
+func (r *Recorder) SetClientCertificate(cert tls.Certificate) {
+	r.tlsConfig().Certificates = []tls.Certificate{cert}
+}
+
+// LoadClientCertificate is like SetClientCertificate, but the certificate and
+// its private key are loaded from a pair of PEM-encoded files. Any errors that
+// occur will be passed to t.Fatal.
+func (r *Recorder) LoadClientCertificate(certFile, keyFile string) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	r.SetClientCertificate(cert)
+}
+
+// SetRootCAs sets the certificate authorities which the recorder uses to
+// verify the certificates of servers, replacing any which were previously
+// trusted (including the certificate of the server of a recorder created with
+// NewTLSRecorder). If pool is nil, the system's certificate authorities are
+// used.
+func (r *Recorder) SetRootCAs(pool *x509.CertPool) {
+	r.tlsConfig().RootCAs = pool
+}
+
+// AddRootCAsFromFile adds the PEM-encoded certificates in the given file to the
+// certificate authorities which the recorder uses to verify the certificates
+// of servers. If no certificate authorities have been set, the certificates
+// are added to a copy of the system's certificate authorities. Any errors that
+// occur, including a file with no valid certificates, will be passed to
+// t.Fatal.
+func (r *Recorder) AddRootCAsFromFile(pemFile string) {
+	data, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	config := r.tlsConfig()
+	if config.RootCAs == nil {
+		if config.RootCAs, err = x509.SystemCertPool(); err != nil {
+			config.RootCAs = x509.NewCertPool()
+		}
+	}
+	if !config.RootCAs.AppendCertsFromPEM(data) {
+		r.t.Fatalf("No valid certificates were found in %s", pemFile)
+	}
+}
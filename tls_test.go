@@ -0,0 +1,199 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate is a certificate and private key generated for a test.
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCertificate generates a certificate for commonName which is signed
+// by parent, or self-signed if parent is nil.
+func newTestCertificate(t *testing.T, commonName string, isCA bool, usage []x509.ExtKeyUsage, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           usage,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCertificate{cert: cert, key: key, der: der}
+}
+
+// tlsCertificate returns c as a tls.Certificate.
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+// writePEM writes c and its private key to PEM-encoded files in dir and
+// returns their paths.
+func (c *testCertificate) writePEM(t *testing.T, dir string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, c.cert.Subject.CommonName+".crt")
+	keyFile := filepath.Join(dir, c.cert.Subject.CommonName+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// peerHandler responds with the common name of the client certificate of
+// each request.
+var peerHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		http.Error(w, "no client certificate", http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+})
+
+// newMutualTLSRecorder returns a recorder whose server requires a client
+// certificate signed by ca.
+func newMutualTLSRecorder(t *testing.T, ca *testCertificate) *Recorder {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return NewTLSRecorder(t, peerHandler, &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCertificate(t, "test-ca", true, nil, nil)
+	client := newTestCertificate(t, "test-client", false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, ca)
+	rec := newMutualTLSRecorder(t, ca)
+	defer rec.Close()
+
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Get("/")
+	}) {
+		t.Error("Expected the handshake to fail without a client certificate")
+	}
+	rec.t = t
+
+	rec.SetClientCertificate(client.tlsCertificate())
+	rec.Get("/").ExpectBodyEquals("test-client")
+}
+
+func TestMutualTLSUntrustedClient(t *testing.T) {
+	ca := newTestCertificate(t, "test-ca", true, nil, nil)
+	otherCA := newTestCertificate(t, "other-ca", true, nil, nil)
+	untrusted := newTestCertificate(t, "untrusted-client", false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, otherCA)
+	wrongUsage := newTestCertificate(t, "server-only", false, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, ca)
+	for _, cert := range []*testCertificate{untrusted, wrongUsage} {
+		rec := newMutualTLSRecorder(t, ca)
+		rec.SetClientCertificate(cert.tlsCertificate())
+		if !failed(func(t *testing.T) {
+			rec.t = t
+			rec.Get("/")
+		}) {
+			t.Errorf("Expected the handshake to fail with client certificate %s", cert.cert.Subject.CommonName)
+		}
+		rec.Close()
+	}
+}
+
+func TestLoadClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, "test-ca", true, nil, nil)
+	client := newTestCertificate(t, "test-client", false, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, ca)
+	certFile, keyFile := client.writePEM(t, dir)
+	rec := newMutualTLSRecorder(t, ca)
+	defer rec.Close()
+
+	rec.LoadClientCertificate(certFile, keyFile)
+	rec.Get("/").ExpectBodyEquals("test-client")
+
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.LoadClientCertificate(filepath.Join(dir, "missing.crt"), keyFile)
+	}) {
+		t.Error("Expected LoadClientCertificate with a missing file to fail the test")
+	}
+}
+
+func TestRootCAs(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewTLSRecorder(t, bodyHandler("ok"), nil)
+	defer rec.Close()
+	serverFile := filepath.Join(dir, "server.crt")
+	serverPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rec.server.Certificate().Raw})
+	if err := os.WriteFile(serverFile, serverPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec.Get("/").ExpectBodyEquals("ok")
+
+	// The test certificate is not trusted by an empty pool.
+	rec.SetRootCAs(x509.NewCertPool())
+	rec.transport.CloseIdleConnections()
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.Get("/")
+	}) {
+		t.Error("Expected the handshake to fail when the server certificate is not trusted")
+	}
+	rec.t = t
+
+	rec.AddRootCAsFromFile(serverFile)
+	rec.Get("/").ExpectBodyEquals("ok")
+
+	invalid := filepath.Join(dir, "invalid.crt")
+	if err := os.WriteFile(invalid, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !failed(func(t *testing.T) {
+		rec.t = t
+		rec.AddRootCAsFromFile(invalid)
+	}) {
+		t.Error("Expected AddRootCAsFromFile with no valid certificates to fail the test")
+	}
+}
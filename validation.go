@@ -0,0 +1,79 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationErrorShape describes where validation errors are located in the
+// JSON body of an error response. It is used by
+// Response.ExpectValidationError.
+type ValidationErrorShape struct {
+	// ErrorsPath is the dot-separated path of the validation errors within
+	// the response body. The value at ErrorsPath may be either an array of
+	// errors or a single error.
+	ErrorsPath string
+	// FieldPath is the dot-separated path of the name of the invalid field
+	// within each error.
+	FieldPath string
+	// ReasonPath is the dot-separated path of the reason the field is invalid
+	// within each error.
+	ReasonPath string
+}
+
+// DefaultValidationErrorShape matches error responses such as:
+//
+//	{"errors": [{"field": "email", "reason": "is required"}]}
+var DefaultValidationErrorShape = ValidationErrorShape{
+	ErrorsPath: "errors",
+	FieldPath:  "field",
+	ReasonPath: "reason",
+}
+
+// ExpectValidationError causes a test error if the response code is not 4xx
+// or if the JSON body of the response does not contain a validation error for
+// the given field whose reason contains the given reason. If reason is empty,
+// only the field is checked. The location of the errors within the body is
+// determined by the ValidationErrorShape option of the recorder. Any errors
+// that occur while decoding the body will be passed to t.Fatal.
+func (r *Response) ExpectValidationError(field, reason string) {
+	r.subtest(fmt.Sprintf("ExpectValidationError(%s)", field), func() {
+		if r.StatusCode < 400 || r.StatusCode > 499 {
+			r.PrintFailureOnce()
+			r.errorf("Expected response code to be 4xx but got: %d", r.StatusCode)
+			return
+		}
+		shape := r.recorder.ValidationErrorShape
+		v, found := r.expectJSONField(shape.ErrorsPath)
+		if !found {
+			return
+		}
+		errs, isArray := v.([]interface{})
+		if !isArray {
+			errs = []interface{}{v}
+		}
+		reasons := []string{}
+		for _, e := range errs {
+			f, _ := lookupJSONPath(e, shape.FieldPath)
+			if f != field {
+				continue
+			}
+			rsn, _ := lookupJSONPath(e, shape.ReasonPath)
+			s, _ := rsn.(string)
+			if strings.Contains(s, reason) {
+				return
+			}
+			reasons = append(reasons, jsonString(rsn))
+		}
+		r.PrintFailureOnce()
+		if len(reasons) == 0 {
+			r.errorf("Expected a validation error for field %q but there was none.", field)
+		} else {
+			r.errorf("Expected a validation error for field %q with reason %q but got: %s", field, reason, strings.Join(reasons, ", "))
+		}
+	})
+}
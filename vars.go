@@ -0,0 +1,145 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// varPattern matches a reference to one of the Vars of a recorder, e.g.
+// "{{baseId}}".
+var varPattern = regexp.MustCompile(`{{\s*([A-Za-z0-9_.-]+)\s*}}`)
+
+// substituteVars replaces every reference to a variable (e.g. "{{baseId}}")
+// in the path, query string, and body of req with the corresponding value from
+// r.Vars, formatted with fmt.Sprint. The query string is substituted in place,
+// so the order and encoding of parameters without any references are
+// preserved. Form data is decoded before the substitution and encoded again
+// afterwards. Other bodies are only substituted if their Content-Type is text
+// (text/*) or JSON, so binary and multipart bodies are left as-is. In JSON
+// bodies, values are escaped as in a JSON string, so that a value containing
+// a quote or backslash can be used in e.g. {"name": "{{name}}"}. A reference
+// to a variable which does not exist will be passed to t.Fatal.
+func (r *Recorder) substituteVars(req *http.Request) {
+	if len(r.Vars) == 0 {
+		return
+	}
+	req.URL.Path = r.expandVars(req.URL.Path)
+	req.URL.RawPath = ""
+	if req.URL.RawQuery != "" {
+		req.URL.RawQuery = r.expandQueryVars(req.URL.RawQuery)
+	}
+	if req.Body == nil {
+		return
+	}
+	contentType := req.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(r.readRequestBody(req)))
+		if err != nil {
+			r.t.Fatal(err)
+		}
+		for key, values := range form {
+			for i, value := range values {
+				values[i] = r.expandVars(value)
+			}
+			form[key] = values
+		}
+		setRequestBody(req, []byte(form.Encode()))
+	case isJSONContentType(contentType):
+		body := r.readRequestBody(req)
+		if bytes.Contains(body, []byte("{{")) {
+			setRequestBody(req, []byte(r.expandVarsWith(string(body), r.Vars, jsonEscape)))
+		}
+	case strings.HasPrefix(mediaType, "text/"):
+		body := r.readRequestBody(req)
+		if bytes.Contains(body, []byte("{{")) {
+			setRequestBody(req, []byte(r.expandVars(string(body))))
+		}
+	}
+}
+
+// expandQueryVars returns the encoded query string rawQuery with every
+// reference to a variable in its keys and values replaced. Each key and value
+// which contains a reference is decoded, substituted, and encoded again. All
+// others are left exactly as they were. A reference to a variable which does
+// not exist will be passed to t.Fatal.
+func (r *Recorder) expandQueryVars(rawQuery string) string {
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		parts := strings.SplitN(param, "=", 2)
+		for j, part := range parts {
+			decoded, err := url.QueryUnescape(part)
+			if err != nil || !varPattern.MatchString(decoded) {
+				continue
+			}
+			parts[j] = url.QueryEscape(r.expandVars(decoded))
+		}
+		params[i] = strings.Join(parts, "=")
+	}
+	return strings.Join(params, "&")
+}
+
+// expandVars returns s with every reference to a variable replaced by the
+// corresponding value from r.Vars. A reference to a variable which does not
+// exist will be passed to t.Fatal.
+func (r *Recorder) expandVars(s string) string {
+	return r.expandVarsFrom(s, r.Vars)
+}
+
+// expandVarsFrom is like expandVars, but the values of the variables are
+// taken from vars instead of r.Vars.
+func (r *Recorder) expandVarsFrom(s string, vars map[string]interface{}) string {
+	return r.expandVarsWith(s, vars, nil)
+}
+
+// expandVarsWith is like expandVarsFrom, but if escape is not nil, each value
+// is passed through escape after being formatted.
+func (r *Recorder) expandVarsWith(s string, vars map[string]interface{}, escape func(string) string) string {
+	return varPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		name := varPattern.FindStringSubmatch(ref)[1]
+		value, found := vars[name]
+		if !found {
+			r.t.Fatalf("No variable named %q has been set.", name)
+		}
+		if escape != nil {
+			return escape(fmt.Sprint(value))
+		}
+		return fmt.Sprint(value)
+	})
+}
+
+// jsonEscape returns s escaped as the contents of a JSON string, without the
+// surrounding quotes. Unlike json.Marshal, it does not escape <, >, and &.
+func jsonEscape(s string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	// Encoding a string cannot fail.
+	encoder.Encode(s)
+	quoted := bytes.TrimSpace(buf.Bytes())
+	return string(quoted[1 : len(quoted)-1])
+}
+
+// ExpectJSONFieldEqualsVar causes a test error if the JSON value at the given
+// dot-separated path is not equal to the variable with the given name in the
+// Vars of the recorder. The values are compared in the same way as
+// ExpectJSONField. If there is no such variable, the failure is passed to
+// t.Fatal.
+func (r *Response) ExpectJSONFieldEqualsVar(path string, name string) {
+	value, found := r.recorder.Vars[name]
+	if !found {
+		r.recorder.t.Fatalf("No variable named %q has been set in Vars.", name)
+	}
+	r.ExpectJSONField(path, value)
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Alex Browne.  All rights reserved.
+// Use of this source code is governed by the MIT
+// license, which can be found in the LICENSE file.
+
+package fipple
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// echoRequestHandler responds with the path, raw query string, and body of
+// each request.
+var echoRequestHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	w.Write([]byte(req.URL.Path + "?" + req.URL.RawQuery + "\n" + string(body)))
+})
+
+func TestSubstituteVarsQuery(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	rec.Vars["id"] = 42
+	rec.Vars["name"] = "a b&c"
+
+	rec.Get("/users/{{id}}?z=1&b={{id}}&a=%2F&{{name}}=x&b=2").
+		ExpectBodyEquals("/users/42?z=1&b=42&a=%2F&a+b%26c=x&b=2\n")
+
+	// References which were encoded by url.Values are also substituted.
+	req := rec.NewRequest("GET", "/users")
+	req.URL.RawQuery = "q=%7B%7Bname%7D%7D&sort=desc"
+	rec.Do(req).ExpectBodyEquals("/users?q=a+b%26c&sort=desc\n")
+}
+
+func TestSubstituteVarsBody(t *testing.T) {
+	rec := NewRecorder(t, echoRequestHandler)
+	defer rec.Close()
+	rec.Vars["id"] = 42
+	rec.Vars["name"] = `say "hi" \ <bye>`
+
+	testCases := []struct {
+		contentType string
+		body        string
+		expected    string
+	}{
+		{"application/json", `{"id":{{id}}}`, `{"id":42}`},
+		{"application/vnd.api+json; charset=utf-8", `{"id":{{id}}}`, `{"id":42}`},
+		// Values in JSON bodies are escaped, but values in text bodies are
+		// not.
+		{"application/json", `{"name":"{{name}}"}`, `{"name":"say \"hi\" \\ <bye>"}`},
+		{"text/plain", "id={{id}}", "id=42"},
+		{"text/plain", "name={{name}}", `name=say "hi" \ <bye>`},
+		{"application/x-www-form-urlencoded", "id=%7B%7Bid%7D%7D", "id=42"},
+		// Bodies which are not text are sent exactly as they were, even if
+		// they contain something which looks like an unknown variable.
+		{"application/octet-stream", "\x00{{id}}{{unknown}}", "\x00{{id}}{{unknown}}"},
+		{"", "{{id}}", "{{id}}"},
+		{"multipart/form-data; boundary=x", "--x\r\n{{id}}\r\n--x--\r\n", "--x\r\n{{id}}\r\n--x--\r\n"},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest("POST", rec.baseURL+"/", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		rec.Do(req).ExpectBodyEquals("/?\n" + tc.expected)
+	}
+}